@@ -1,3 +1,4 @@
+{{- /* Keep in sync with `custom-scheduler render-config -format policy` */ -}}
 {
   "kind" : "Policy",
   "apiVersion" : "v1",
@@ -10,7 +11,6 @@
     {"name": "PodToleratesNodeTaints"},
     {"name": "CheckVolumeBinding"},
     {"name": "MaxGCEPDVolumeCount"},
-    {"name": "MatchInterPodAffinity"},
 {{- if semverCompare "~1.11.0" .Capabilities.KubeVersion.GitVersion }}
     {"name": "CheckNodePIDPressure"},
 {{- end }}
@@ -19,7 +19,7 @@
     {"name": "CheckNodeMemoryPressure"},
     {"name": "CheckNodeDiskPressure"},
 {{- end }}
-    {"name": "CheckVolumeBinding"}
+    {"name": "MatchInterPodAffinity"}
   ],
   "priorities": [
     {"name": "SelectorSpreadPriority", "weight": 1},
@@ -35,6 +35,9 @@
       "urlPrefix": "{{ .Values.scheduler.extenders.url }}:8080/scheduler",
       "filterVerb": "filter",
       "prioritizeVerb": "prioritize",
+{{- if .Values.scheduler.extenders.bind }}
+      "bindVerb": "bind",
+{{- end }}
       "weight": 1,
       "httpTimeout": 30000000000,
       "enableHttps": false,
@@ -2,31 +2,130 @@ package main
 
 import (
 	"os"
+	"github.com/gin-gonic/gin"
+	"strings"
+	"fmt"
+	"time"
 	"flag"
 	"k8s.io/klog"
 
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 	opt "github.com/xkcp0324/custom-scheduler/cmd/custom-scheduler/option"
 	"github.com/xkcp0324/custom-scheduler/pkg/version"
 	"github.com/xkcp0324/custom-scheduler/pkg/router"
+	"github.com/xkcp0324/custom-scheduler/pkg/router/auth"
+	"github.com/xkcp0324/custom-scheduler/pkg/router/limits"
+	"github.com/xkcp0324/custom-scheduler/pkg/observability/logging"
+	"github.com/xkcp0324/custom-scheduler/pkg/observability/tracing"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"k8s.io/client-go/kubernetes"
 	"sigs.k8s.io/controller-runtime/pkg/client/config"
 	"sigs.k8s.io/controller-runtime/pkg/manager/signals"
 	"github.com/xkcp0324/custom-scheduler/pkg/scheduler"
+	"github.com/xkcp0324/custom-scheduler/pkg/scheduler/metrics"
+	"github.com/xkcp0324/custom-scheduler/pkg/scheduler/predicates"
+	"github.com/xkcp0324/custom-scheduler/pkg/scheduler/reservation"
 	"github.com/xkcp0324/custom-scheduler/pkg/healthcheck"
+	"github.com/xkcp0324/custom-scheduler/pkg/rebalancer"
+	"github.com/xkcp0324/custom-scheduler/pkg/sli"
+	"github.com/xkcp0324/custom-scheduler/pkg/standalone"
 	"k8s.io/klog/klogr"
 )
 
 
+// minLatencySamples is the number of extender calls below which the latency SLO
+// is not checked
+const minLatencySamples = 20
+
+// inspectPaths are the paths of the inspect routes, they require auth
+var inspectPaths = []string{
+	scheduler.URLPath + "/explain",
+	scheduler.URLPath + "/decisions",
+}
+
+// listeners splits the routes over the extender, admin and debug addresses. The
+// extender only serves kube-scheduler, the inspect routes go to the admin address.
+func listeners(options *opt.Options) []router.ListenerOptions {
+	extender := router.ListenerOptions{
+		Name:         "extender",
+		Addr:         options.BindAddressPort,
+		CertFilePath: options.ExtenderTLSCertFile,
+		KeyFilePath:  options.ExtenderTLSKeyFile,
+		SelfSigned:   options.ExtenderTLSSelfSigned,
+		ClientCAFile: options.ExtenderClientCAFile,
+		Groups:       []string{"scheduler"},
+		Limited:      true,
+	}
+	for _, subject := range strings.Split(options.ExtenderAllowedSubjects, ",") {
+		if subject = strings.TrimSpace(subject); subject != "" {
+			extender.AllowedSubjects = append(extender.AllowedSubjects, subject)
+		}
+	}
+	if options.AdminBindAddress == "" {
+		extender.Groups = append(extender.Groups, "rt", "health", "inspect")
+		extender.ServeMetrics = true
+		extender.ProtectedPaths = append(extender.ProtectedPaths, router.MetricsPath)
+		extender.ProtectedPaths = append(extender.ProtectedPaths, inspectPaths...)
+	}
+	ret := []router.ListenerOptions{extender}
+
+	if options.AdminBindAddress != "" {
+		ret = append(ret, router.ListenerOptions{
+			Name:           "admin",
+			Addr:           options.AdminBindAddress,
+			CertFilePath:   options.AdminTLSCertFile,
+			KeyFilePath:    options.AdminTLSKeyFile,
+			Groups:         []string{"rt", "health", "inspect"},
+			ProtectedPaths: append([]string{router.MetricsPath}, inspectPaths...),
+			ServeMetrics:   true,
+		})
+	}
+
+	if options.DebugBindAddress != "" {
+		ret = append(ret, router.ListenerOptions{
+			Name:           "debug",
+			Addr:           options.DebugBindAddress,
+			CertFilePath:   options.DebugTLSCertFile,
+			KeyFilePath:    options.DebugTLSKeyFile,
+			ProtectedPaths: []string{router.PprofPath},
+			ServePprof:     true,
+		})
+	}
+	return ret
+}
+
+// routePaths returns the paths of the routes
+func routePaths(routes []*router.Route) []string {
+	ret := make([]string, 0, len(routes))
+	for _, route := range routes {
+		ret = append(ret, route.Path)
+	}
+	return ret
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "render-config" {
+		if err := renderConfig(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "render-config: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	var options opt.Options
 	options.BindFlags()
 	klog.InitFlags(nil)
 	flag.Parse()
 
-	// logf.SetLogger(zap.Logger(false))
-	logf.SetLogger(klogr.New())
+	switch options.LogFormat {
+	case logging.FormatJSON:
+		logf.SetLogger(zap.Logger(false))
+	case logging.FormatText:
+		logf.SetLogger(klogr.New())
+	default:
+		klog.Fatalf("unknown log format %q", options.LogFormat)
+	}
 	loggger := logf.Log.WithName("entrypoint")
 
 	// print version and exist
@@ -66,20 +165,145 @@ func main() {
 	routerOptions := &router.RouterOptions{
 		IsGinLogEnabled:  true,
 		IsMetricsEnabled: true,
-		IsPprofEnabled:   true,
-		Addr:             options.BindAddressPort,
 		MetricsPath:      "metrics",
 		MetricsSubsystem: "custom_scheduler",
+		Logger:           logf.Log.WithName("router"),
+		Listeners:        listeners(&options),
+	}
+
+	if options.EnableAuth {
+		attrs, err := auth.ParseResourceAttributes(options.AuthResourceAttributes)
+		if err != nil {
+			loggger.Error(err, "unable to parse auth resource attributes")
+			os.Exit(1)
+		}
+		routerOptions.Auth = auth.NewAuthorizer(kubeCli, &auth.Options{
+			ResourceAttributes: attrs,
+			CacheTTL:           options.AuthCacheTTL,
+		}).Middleware()
+	}
+
+	if options.TraceExporter != "" {
+		tracer, err := tracing.NewTracer(&tracing.Options{
+			ServiceName: "custom-scheduler",
+			Exporter:    options.TraceExporter,
+			Endpoint:    options.TraceEndpoint,
+		})
+		if err != nil {
+			loggger.Error(err, "unable to set up tracing")
+			os.Exit(1)
+		}
+		if err := mgr.Add(tracer); err != nil {
+			loggger.Error(err, "unable to add tracer")
+			os.Exit(1)
+		}
+		routerOptions.Tracer = tracer
+	}
+
+	coLocationPeers, err := predicates.ParseCoLocationPeers(options.CoLocationPeers)
+	if err != nil {
+		loggger.Error(err, "unable to parse colocation peers")
+		os.Exit(1)
+	}
+
+	schedulerOptions := &scheduler.SchedulerOptions{
+		CoLocation: predicates.CoLocationArgs{
+			Weight:      options.CoLocationWeight,
+			TopologyKey: options.CoLocationTopologyKey,
+			Peers:       coLocationPeers,
+		},
+		Cost: predicates.CostArgs{
+			Weight:                options.CostWeight,
+			PriceLabel:            options.PriceLabel,
+			InterruptibleSelector: options.InterruptibleSelector,
+			MaxInterruptibleShare: options.MaxInterruptibleShare,
+		},
+		ImageLocality: predicates.ImageLocalityArgs{
+			Weight: options.ImageLocalityWeight,
+		},
+		Tenant: predicates.TenantArgs{
+			ConfigFile: options.TenantConfigFile,
+		},
+		PodCount: predicates.PodCountArgs{
+			ConfigFile: options.PodCountConfigFile,
+		},
+		HistorySize: options.HistorySize,
+		HistoryFile: options.HistoryFile,
+
+		EventInterval: options.EventInterval,
+		FailOpen:      options.FilterFailOpen,
+		ArgsDumpEvery: options.ArgsDumpEvery,
+		Reservation: reservation.Options{
+			Backend:         options.ReservationBackend,
+			Namespace:       options.ReservationNamespace,
+			Name:            options.ReservationName,
+			TTL:             options.ReservationTTL,
+			MaxReservations: options.ReservationMax,
+		},
 	}
 
-	healthHander := healthcheck.NewHealthHandler()
+	healthHander := healthcheck.NewHealthHandler(&healthcheck.Options{
+		CheckOptions: healthcheck.CheckOptions{
+			Interval:         options.HealthCheckInterval,
+			Timeout:          options.HealthCheckTimeout,
+			FailureThreshold: options.HealthFailureThreshold,
+		},
+	})
 	healthHander.AddLivenessCheck("goroutine_threshold",
 		healthcheck.GoroutineCountCheck(options.GoroutineThreshold))
 
+	if routerOptions.IsMetricsEnabled {
+		metrics.Register(routerOptions.MetricsSubsystem)
+	}
+
+	schedulerServer, err := scheduler.NewServer(kubeCli, mgr, schedulerOptions)
+	if err != nil {
+		loggger.Error(err, "unable to set up scheduler")
+		os.Exit(1)
+	}
+	routerOptions.Limits = &limits.Options{
+		MaxBodyBytes:     options.MaxRequestBodyBytes,
+		PathPrefix:       "/scheduler/",
+		Paths:            routePaths(schedulerServer.Routes()),
+		MaxInFlight:      options.MaxInFlight,
+		QueueTimeout:     options.QueueTimeout,
+		ClientQPS:        float32(options.ClientQPS),
+		ClientBurst:      options.ClientBurst,
+		MetricsSubsystem: routerOptions.MetricsSubsystem,
+	}
+	if options.ShedFilterFailOpen {
+		routerOptions.Limits.FailOpen = map[string]gin.HandlerFunc{
+			"/scheduler/filter": schedulerServer.FailOpenFilter,
+		}
+	}
+
+	healthHander.AddReadinessCheck("cache_synced",
+		healthcheck.CacheSyncCheck(mgr.GetCache(), options.HealthCheckTimeout))
+	healthHander.AddReadinessCheck("apiserver",
+		healthcheck.APIServerCheck(kubeCli.Discovery()))
+	healthHander.AddReadinessCheck("config_loaded",
+		healthcheck.ConfigLoadedCheck(schedulerServer.ConfigErr))
+	if options.LatencySLO > 0 {
+		healthHander.AddReadinessCheck("latency_slo",
+			healthcheck.LatencySLOCheck(func() (time.Duration, int) {
+				return schedulerServer.Latency(options.LatencySLOQuantile, options.LatencySLOWindow)
+			}, options.LatencySLO, minLatencySamples))
+	}
+	if err := mgr.Add(healthHander); err != nil {
+		loggger.Error(err, "unable to add health checks")
+		os.Exit(1)
+	}
+
 	rt := router.NewRouter(routerOptions)
 	rt.AddRoutes("rt", router.DefaultRoutes())
-	rt.AddRoutes("scheduler", scheduler.NewServer(kubeCli, mgr).Routes())
+	rt.AddRoutes("scheduler", schedulerServer.Routes())
+	rt.AddRoutes("inspect", schedulerServer.InspectRoutes())
 	rt.AddRoutes("health", healthHander.Routes())
+	// the extender is served on every replica, each one reports its own listeners
+	healthHander.AddReadinessCheckWithOptions("serving", rt.Serving, &healthcheck.CheckOptions{
+		Interval:         time.Second,
+		FailureThreshold: 1,
+	})
 
 	loggger.Info("adding gin http server")
 	err = mgr.Add(rt)
@@ -88,6 +312,55 @@ func main() {
 		os.Exit(1)
 	}
 
+	if options.EnableRebalancer {
+		loggger.Info("adding rebalancer")
+		rb := rebalancer.NewRebalancer(kubeCli, mgr, &rebalancer.Options{
+			Interval:      options.RebalanceInterval,
+			MaxSkew:       options.RebalanceMaxSkew,
+			EvictionQPS:   float32(options.RebalanceEvictionQPS),
+			EvictionBurst: options.RebalanceEvictionBurst,
+			DryRun:        options.RebalanceDryRun,
+		})
+		if err := mgr.Add(rb); err != nil {
+			loggger.Error(err, "Unable to add rebalancer")
+			os.Exit(1)
+		}
+	}
+
+	if options.Standalone {
+		loggger.Info("adding standalone scheduler", "schedulerName", options.SchedulerName)
+		sa := standalone.NewStandalone(mgr, schedulerServer.Scheduler(), &standalone.Options{
+			SchedulerName:  options.SchedulerName,
+			InitialBackoff: options.StandaloneInitialBackoff,
+			MaxBackoff:     options.StandaloneMaxBackoff,
+		})
+		if err := mgr.Add(sa); err != nil {
+			loggger.Error(err, "Unable to add standalone scheduler")
+			os.Exit(1)
+		}
+	}
+
+	if options.EnableSLI {
+		if !options.LeaderElection {
+			klog.Warning("the sli controller runs without leader election, every replica records the pods and the latency is counted once per replica")
+		}
+		loggger.Info("adding sli controller", "schedulerName", options.SchedulerName)
+		sliController, err := sli.NewController(mgr, &sli.Options{
+			SchedulerName:    options.SchedulerName,
+			PendingThreshold: options.SLIPendingThreshold,
+			Interval:         options.SLIInterval,
+			MetricsSubsystem: routerOptions.MetricsSubsystem,
+		})
+		if err != nil {
+			loggger.Error(err, "unable to set up sli controller")
+			os.Exit(1)
+		}
+		if err := mgr.Add(sliController); err != nil {
+			loggger.Error(err, "Unable to add sli controller")
+			os.Exit(1)
+		}
+	}
+
 	loggger.Info("Starting the Cmd.")
 	if err := mgr.Start(signals.SetupSignalHandler()); err != nil {
 		loggger.Error(err, "unable to run the manager")
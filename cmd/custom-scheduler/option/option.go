@@ -3,9 +3,20 @@ package config
 import (
 	"flag"
 	"fmt"
+	"github.com/xkcp0324/custom-scheduler/pkg/healthcheck"
+	"github.com/xkcp0324/custom-scheduler/pkg/observability/logging"
+	"github.com/xkcp0324/custom-scheduler/pkg/observability/tracing"
+	"github.com/xkcp0324/custom-scheduler/pkg/observe"
+	"github.com/xkcp0324/custom-scheduler/pkg/router/auth"
+	"github.com/xkcp0324/custom-scheduler/pkg/router/limits"
+	"github.com/xkcp0324/custom-scheduler/pkg/scheduler/predicates"
+	"github.com/xkcp0324/custom-scheduler/pkg/scheduler/reservation"
+	"github.com/xkcp0324/custom-scheduler/pkg/sli"
+	"github.com/xkcp0324/custom-scheduler/pkg/standalone"
 	"k8s.io/klog"
 	"os"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"time"
 )
 
 const (
@@ -15,11 +26,128 @@ const (
 
 // Options contains all the options for captain
 type Options struct {
-	BindAddressPort string
+	// BindAddressPort is the address of the extender endpoints
+	BindAddressPort     string
+	ExtenderTLSCertFile string
+	ExtenderTLSKeyFile  string
+
+	// Mutual TLS of the extender endpoints, the client CA and the comma separated
+	// subjects allowed to call them, and a self-signed certificate for dev clusters
+	ExtenderClientCAFile    string
+	ExtenderAllowedSubjects string
+	ExtenderTLSSelfSigned   bool
+
+	// AdminBindAddress is the address of the metrics and health endpoints, they
+	// are served with the extender endpoints when it is empty
+	AdminBindAddress string
+	AdminTLSCertFile string
+	AdminTLSKeyFile  string
+
+	// DebugBindAddress is the address of pprof, pprof is disabled when it is empty
+	DebugBindAddress string
+	DebugTLSCertFile string
+	DebugTLSKeyFile  string
 
 	// Max num Goroutine
 	GoroutineThreshold int
 
+	// The health checks run in the background on HealthCheckInterval, a check
+	// becomes unhealthy after HealthFailureThreshold consecutive failures
+	HealthCheckInterval    time.Duration
+	HealthCheckTimeout     time.Duration
+	HealthFailureThreshold int
+
+	// LatencySLO makes the pod unready while the LatencySLOQuantile of the
+	// extender latency over LatencySLOWindow exceeds it, disabled when it is 0
+	LatencySLO         time.Duration
+	LatencySLOQuantile float64
+	LatencySLOWindow   time.Duration
+
+	// CoLocation priority weight, topology key and app => peers policy
+	CoLocationWeight      int
+	CoLocationTopologyKey string
+	CoLocationPeers       string
+
+	// Cost priority weight, node price label and interruptible nodes
+	CostWeight            int
+	PriceLabel            string
+	InterruptibleSelector string
+	MaxInterruptibleShare float64
+
+	// ImageLocality priority weight
+	ImageLocalityWeight int
+
+	// TenantConfigFile maps the tenant namespaces to their dedicated node pools
+	TenantConfigFile string
+
+	// PodCountConfigFile holds the per node pod count limits of the namespaces
+	PodCountConfigFile string
+
+	// Rebalancer evicts the surplus replicas of the apps spread unevenly
+	EnableRebalancer       bool
+	RebalanceInterval      time.Duration
+	RebalanceMaxSkew       int
+	RebalanceEvictionQPS   float64
+	RebalanceEvictionBurst int
+	RebalanceDryRun        bool
+
+	// Decision history size and optional persistent file
+	HistorySize int
+	HistoryFile string
+
+	// EventInterval is the min interval between two events of a pod with the same reason
+	EventInterval time.Duration
+
+	// FilterFailOpen skips a failing predicate instead of failing the filter
+	FilterFailOpen bool
+
+	// Overload protection of the router, the limits are disabled when they are 0.
+	// The concurrency and client rate limits apply to the extender endpoints.
+	MaxRequestBodyBytes int64
+	MaxInFlight         int
+	QueueTimeout        time.Duration
+	ClientQPS           float64
+	ClientBurst         int
+	ShedFilterFailOpen  bool
+
+	// EnableAuth guards the metrics, pprof, explain and decisions endpoints with
+	// TokenReview and SubjectAccessReview, on the resource attributes when set.
+	// The endpoints are not served when it is disabled.
+	EnableAuth             bool
+	AuthResourceAttributes string
+	AuthCacheTTL           time.Duration
+
+	// TraceExporter exports the request traces, either otlp or stdout, tracing is
+	// disabled when it is empty
+	TraceExporter string
+	TraceEndpoint string
+
+	// Reservation shares the pods being bound through the bind verb, in memory or
+	// in a ConfigMap read by every replica
+	ReservationBackend   string
+	ReservationNamespace string
+	ReservationName      string
+	ReservationTTL       time.Duration
+	ReservationMax       int
+
+	// Standalone schedules the pods of SchedulerName in process instead of
+	// extending kube-scheduler
+	Standalone               bool
+	SchedulerName            string
+	StandaloneInitialBackoff time.Duration
+	StandaloneMaxBackoff     time.Duration
+
+	// SLI measures the scheduling latency of the pods of SchedulerName
+	EnableSLI           bool
+	SLIPendingThreshold time.Duration
+	SLIInterval         time.Duration
+
+	// LogFormat is either text (klog) or json (zap), it applies to the structured logs
+	LogFormat string
+
+	// ArgsDumpEvery logs the extender args of one of every ArgsDumpEvery requests
+	ArgsDumpEvery int
+
 	// PrintVersion print the version and exist
 	PrintVersion bool
 
@@ -37,8 +165,70 @@ func (opt *Options) BindFlags() {
 
 	flag.BoolVar(&opt.PrintVersion, "version", false, "Print version")
 	flag.IntVar(&opt.GoroutineThreshold, "goroutine-threshold", 200, "check the max goroutine num")
-	flag.BoolVar(&opt.LeaderElection, "enable-leader-election", false, "Enable leader election")
-	flag.StringVar(&opt.BindAddressPort, "bind-address-port", ":8080", "Setup bind address for metrics and scheduler endpoint")
+	flag.DurationVar(&opt.HealthCheckInterval, "health-check-interval", healthcheck.DefaultInterval, "Interval between two runs of a health check")
+	flag.DurationVar(&opt.HealthCheckTimeout, "health-check-timeout", healthcheck.DefaultTimeout, "Timeout of a run of a health check")
+	flag.IntVar(&opt.HealthFailureThreshold, "health-failure-threshold", healthcheck.DefaultFailureThreshold, "Consecutive failures before a health check is unhealthy")
+	flag.DurationVar(&opt.LatencySLO, "latency-slo", 0, "Extender latency above which the pod is unready, disabled when 0. An unready replica gets no extender call until the window drains, so every replica may turn unready at once")
+	flag.Float64Var(&opt.LatencySLOQuantile, "latency-slo-quantile", 0.99, "Quantile of the extender latency checked against the latency SLO")
+	flag.DurationVar(&opt.LatencySLOWindow, "latency-slo-window", 5*time.Minute, "Window of the extender calls checked against the latency SLO")
+	flag.BoolVar(&opt.LeaderElection, "enable-leader-election", false, "Enable leader election, every replica serves the extender and only the background controllers such as the rebalancer run on the leader")
+	flag.StringVar(&opt.BindAddressPort, "bind-address-port", ":8080", "Setup bind address for the scheduler extender endpoint")
+	flag.StringVar(&opt.ExtenderTLSCertFile, "extender-tls-cert-file", "", "TLS certificate of the scheduler extender endpoint")
+	flag.StringVar(&opt.ExtenderTLSKeyFile, "extender-tls-key-file", "", "TLS private key of the scheduler extender endpoint")
+	flag.StringVar(&opt.ExtenderClientCAFile, "extender-client-ca-file", "", "CA verifying the client certificates of the scheduler extender endpoint, enables mutual TLS")
+	flag.StringVar(&opt.ExtenderAllowedSubjects, "extender-allowed-subjects", "", "Comma separated common names or SANs of the clients allowed to call the scheduler extender endpoint, any verified client when empty")
+	flag.BoolVar(&opt.ExtenderTLSSelfSigned, "extender-tls-self-signed", false, "Generate a self-signed certificate for the scheduler extender endpoint, written to the cert files when they are set and missing")
+	flag.StringVar(&opt.AdminBindAddress, "admin-bind-address", ":8081", "Setup bind address for the metrics and health endpoints, served on the extender address when empty")
+	flag.StringVar(&opt.AdminTLSCertFile, "admin-tls-cert-file", "", "TLS certificate of the metrics and health endpoints")
+	flag.StringVar(&opt.AdminTLSKeyFile, "admin-tls-key-file", "", "TLS private key of the metrics and health endpoints")
+	flag.StringVar(&opt.DebugBindAddress, "debug-bind-address", "", "Setup bind address for pprof, pprof is disabled when empty")
+	flag.StringVar(&opt.DebugTLSCertFile, "debug-tls-cert-file", "", "TLS certificate of the pprof endpoint")
+	flag.StringVar(&opt.DebugTLSKeyFile, "debug-tls-key-file", "", "TLS private key of the pprof endpoint")
+	flag.IntVar(&opt.CoLocationWeight, "colocation-weight", 1, "Weight of the co-location priority")
+	flag.StringVar(&opt.CoLocationTopologyKey, "colocation-topology-key", predicates.DefaultCoLocationTopologyKey, "Node label which groups nodes into zones for the co-location priority")
+	flag.StringVar(&opt.CoLocationPeers, "colocation-peers", "", "Co-location policy of the form app1=peer1,peer2;app2=peer3")
+	flag.IntVar(&opt.CostWeight, "cost-weight", 1, "Weight of the cost priority")
+	flag.StringVar(&opt.PriceLabel, "price-label", observe.ObserveLabelNodePrice, "Node label holding the price of the node")
+	flag.StringVar(&opt.InterruptibleSelector, "interruptible-selector", predicates.DefaultInterruptibleSelector, "Label selector of the interruptible (spot) nodes")
+	flag.Float64Var(&opt.MaxInterruptibleShare, "max-interruptible-share", predicates.DefaultMaxInterruptibleShare, "Max share of the replicas of an app on interruptible nodes")
+	flag.IntVar(&opt.ImageLocalityWeight, "image-locality-weight", 1, "Weight of the image locality priority")
+	flag.StringVar(&opt.TenantConfigFile, "tenant-config", "", "Path of the tenant node pools config, reloaded when it changes")
+	flag.StringVar(&opt.PodCountConfigFile, "pod-count-config", "", "Path of the per node pod count limits config, reloaded when it changes")
+	flag.BoolVar(&opt.EnableRebalancer, "enable-rebalancer", false, "Enable the HA rebalancer, it only runs on the leader")
+	flag.DurationVar(&opt.RebalanceInterval, "rebalance-interval", 5*time.Minute, "Interval between two rebalance rounds")
+	flag.IntVar(&opt.RebalanceMaxSkew, "rebalance-max-skew", 1, "Max difference of replicas between the nodes of an app before the rebalancer evicts one")
+	flag.Float64Var(&opt.RebalanceEvictionQPS, "rebalance-eviction-qps", 0.1, "Max evictions per second of the rebalancer")
+	flag.IntVar(&opt.RebalanceEvictionBurst, "rebalance-eviction-burst", 1, "Max burst of evictions of the rebalancer")
+	flag.BoolVar(&opt.RebalanceDryRun, "rebalance-dry-run", false, "Only report the pods the rebalancer would evict")
+	flag.IntVar(&opt.HistorySize, "history-size", 1000, "Number of filter and prioritize decisions kept in memory")
+	flag.StringVar(&opt.HistoryFile, "history-file", "", "File persisting the decision history across restarts, disabled when empty")
+	flag.DurationVar(&opt.EventInterval, "event-interval", time.Minute, "Min interval between two events of a pod with the same reason")
+	flag.BoolVar(&opt.FilterFailOpen, "filter-fail-open", false, "Keep the nodes of a failing predicate instead of failing the filter")
+	flag.Int64Var(&opt.MaxRequestBodyBytes, "max-request-body-bytes", 64<<20, "Max size of a request body, unlimited when 0")
+	flag.IntVar(&opt.MaxInFlight, "max-in-flight", 64, "Max concurrent requests to the scheduler extender endpoints, unlimited when 0")
+	flag.DurationVar(&opt.QueueTimeout, "queue-timeout", limits.DefaultQueueTimeout, "How long a request waits for a free slot before it is rejected with 429")
+	flag.Float64Var(&opt.ClientQPS, "client-qps", 0, "Max requests per second of a client to the scheduler extender endpoints, unlimited when 0")
+	flag.IntVar(&opt.ClientBurst, "client-burst", 100, "Max burst of requests of a client to the scheduler extender endpoints")
+	flag.BoolVar(&opt.ShedFilterFailOpen, "shed-filter-fail-open", false, "Answer the filter requests shed by the limits with every node instead of 429")
+	flag.BoolVar(&opt.EnableAuth, "enable-auth", true, "Require a bearer token authorized by a SubjectAccessReview on the metrics, pprof, explain and decisions endpoints, they are served without authentication when it is disabled")
+	flag.StringVar(&opt.AuthResourceAttributes, "auth-resource-attributes", "", "Resource attributes of the SubjectAccessReview, e.g. namespace=kube-system,resource=services,subresource=proxy,name=custom-scheduler, the request path is checked when empty")
+	flag.DurationVar(&opt.AuthCacheTTL, "auth-cache-ttl", auth.DefaultCacheTTL, "How long the token and access reviews are cached")
+	flag.StringVar(&opt.TraceExporter, "trace-exporter", "", "Exporter of the request traces, otlp or stdout, tracing is disabled when empty")
+	flag.StringVar(&opt.TraceEndpoint, "trace-endpoint", tracing.DefaultOTLPEndpoint, "OTLP/HTTP endpoint receiving the traces")
+	flag.StringVar(&opt.ReservationBackend, "reservation-backend", reservation.BackendMemory, "Store of the pods being bound through the bind verb, memory or configmap to share them across replicas")
+	flag.StringVar(&opt.ReservationNamespace, "reservation-namespace", os.Getenv("POD_NAMESPACE"), "Namespace of the reservation ConfigMap")
+	flag.StringVar(&opt.ReservationName, "reservation-name", reservation.DefaultName, "Name of the reservation ConfigMap")
+	flag.DurationVar(&opt.ReservationTTL, "reservation-ttl", reservation.DefaultTTL, "How long a reservation lasts when the binding of its pod is not observed")
+	flag.IntVar(&opt.ReservationMax, "reservation-max", reservation.DefaultMaxReservations, "Max reservations of the configmap backend, it keeps the ConfigMap under the 1 MiB object limit")
+	flag.BoolVar(&opt.Standalone, "standalone", false, "Schedule the pods of -scheduler-name in process instead of extending kube-scheduler, it only runs on the leader")
+	flag.StringVar(&opt.SchedulerName, "scheduler-name", standalone.DefaultSchedulerName, "schedulerName of the pods scheduled in standalone mode and measured by the SLI controller")
+	flag.DurationVar(&opt.StandaloneInitialBackoff, "standalone-initial-backoff", standalone.DefaultInitialBackoff, "Delay before the second try of an unschedulable pod in standalone mode, it doubles on every failure")
+	flag.DurationVar(&opt.StandaloneMaxBackoff, "standalone-max-backoff", standalone.DefaultMaxBackoff, "Max delay between two tries of an unschedulable pod in standalone mode")
+	flag.BoolVar(&opt.EnableSLI, "enable-sli", false, "Record the scheduling latency of the pods of -scheduler-name and the pods pending too long, it requires -enable-leader-election when several replicas run")
+	flag.DurationVar(&opt.SLIPendingThreshold, "sli-pending-threshold", sli.DefaultPendingThreshold, "How long a pod pends before it is counted by the pending_pods gauge")
+	flag.DurationVar(&opt.SLIInterval, "sli-interval", sli.DefaultInterval, "Interval between two updates of the pending pods gauges")
+	flag.StringVar(&opt.LogFormat, "log-format", logging.FormatText, "Format of the structured logs, text or json")
+	flag.IntVar(&opt.ArgsDumpEvery, "args-dump-every", 100, "Log the extender args of one of every N requests at verbosity 4")
 }
 
 // FixKlogFlags copy flags between glog and klog
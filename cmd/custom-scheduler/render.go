@@ -0,0 +1,61 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/xkcp0324/custom-scheduler/pkg/scheduler/policy"
+)
+
+// The formats of render-config
+const (
+	formatPolicy = "policy"
+	formatConfig = "config"
+)
+
+// renderConfig runs the render-config subcommand, it prints the kube-scheduler
+// Policy or KubeSchedulerConfiguration calling this extender
+func renderConfig(args []string) error {
+	fs := flag.NewFlagSet("render-config", flag.ContinueOnError)
+	opt := &policy.Options{}
+	format := fs.String("format", formatPolicy, "What to render, policy (the legacy Policy JSON) or config (the KubeSchedulerConfiguration YAML)")
+	output := fs.String("output", "", "File to write, stdout when empty")
+	fs.StringVar(&opt.KubernetesVersion, "kubernetes-version", policy.DefaultKubernetesVersion, "Version of kube-scheduler")
+	fs.StringVar(&opt.URLPrefix, "url-prefix", policy.DefaultURLPrefix, "URL of the extender endpoints, https enables TLS")
+	fs.IntVar(&opt.Weight, "weight", 1, "Weight of the extender priority")
+	fs.DurationVar(&opt.HTTPTimeout, "http-timeout", policy.DefaultHTTPTimeout, "Timeout of kube-scheduler calling the extender")
+	fs.BoolVar(&opt.Bind, "bind", false, "Let the extender bind the pods, so it records them as reserved")
+	fs.BoolVar(&opt.Ignorable, "ignorable", false, "Let kube-scheduler schedule the pods when the extender fails")
+	fs.StringVar(&opt.CAFile, "tls-ca-file", "", "CA of the extender certificate")
+	fs.StringVar(&opt.CertFile, "tls-cert-file", "", "Client certificate of kube-scheduler, for the extender mutual TLS")
+	fs.StringVar(&opt.KeyFile, "tls-key-file", "", "Client key of kube-scheduler, for the extender mutual TLS")
+	fs.BoolVar(&opt.Insecure, "tls-insecure", false, "Skip the verification of the extender certificate")
+	fs.StringVar(&opt.SchedulerName, "scheduler-name", "custom-scheduler", "Name of the scheduler and of its leader lock")
+	fs.StringVar(&opt.PolicyConfigMap, "policy-configmap", "custom-scheduler-policy", "ConfigMap of the Policy, for the versions before 1.18")
+	fs.StringVar(&opt.Namespace, "namespace", "kube-system", "Namespace of the Policy ConfigMap and of the leader lock")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var data []byte
+	var err error
+	switch *format {
+	case formatPolicy:
+		data, err = policy.RenderPolicy(opt)
+	case formatConfig:
+		data, err = policy.RenderConfig(opt)
+	default:
+		err = fmt.Errorf("unknown format %q", *format)
+	}
+	if err != nil {
+		return err
+	}
+
+	if *output == "" {
+		_, err = os.Stdout.Write(append(data, '\n'))
+		return err
+	}
+	return ioutil.WriteFile(*output, data, 0644)
+}
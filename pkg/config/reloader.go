@@ -0,0 +1,115 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"k8s.io/klog"
+)
+
+const (
+	// DefaultReloadInterval is how often the file is checked for changes
+	DefaultReloadInterval = 10 * time.Second
+)
+
+// LoadFunc parses the content of a config file, the previous config is kept
+// when it returns an error
+type LoadFunc func(data []byte) error
+
+// Reloader loads a config file and reloads it whenever its modification time
+// changes. It is a manager.Runnable that runs on every replica.
+type Reloader struct {
+	lock     sync.RWMutex
+	path     string
+	interval time.Duration
+	load     LoadFunc
+	modTime  time.Time
+	loadedAt time.Time
+	lastErr  error
+}
+
+// NewReloader returns a Reloader of the file at path
+func NewReloader(path string, interval time.Duration, load LoadFunc) *Reloader {
+	if interval <= 0 {
+		interval = DefaultReloadInterval
+	}
+
+	return &Reloader{
+		path:     path,
+		interval: interval,
+		load:     load,
+	}
+}
+
+// Path returns the path of the config file
+func (r *Reloader) Path() string {
+	return r.path
+}
+
+// Load reads and parses the config file if it changed since the last load
+func (r *Reloader) Load() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	info, err := os.Stat(r.path)
+	if err != nil {
+		r.lastErr = err
+		return err
+	}
+	if !r.loadedAt.IsZero() && info.ModTime().Equal(r.modTime) {
+		return r.lastErr
+	}
+
+	data, err := ioutil.ReadFile(r.path)
+	if err != nil {
+		r.lastErr = err
+		return err
+	}
+
+	r.modTime = info.ModTime()
+	r.loadedAt = time.Now()
+	if err := r.load(data); err != nil {
+		r.lastErr = fmt.Errorf("load %s err: %v", r.path, err)
+		return r.lastErr
+	}
+
+	klog.Infof("config %s loaded", r.path)
+	r.lastErr = nil
+	return nil
+}
+
+// Err returns the error of the last load, nil when the current config is valid
+func (r *Reloader) Err() error {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	if r.loadedAt.IsZero() && r.lastErr == nil {
+		return fmt.Errorf("config %s is not loaded", r.path)
+	}
+	return r.lastErr
+}
+
+// Start polls the config file until the stop channel is closed
+func (r *Reloader) Start(stopCh <-chan struct{}) error {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stopCh:
+			return nil
+		case <-ticker.C:
+			if err := r.Load(); err != nil {
+				klog.Errorf("reload config err: %+v", err)
+			}
+		}
+	}
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable, the config is
+// needed by every replica
+func (r *Reloader) NeedLeaderElection() bool {
+	return false
+}
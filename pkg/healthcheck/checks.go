@@ -7,7 +7,11 @@ import (
 	"net"
 	"net/http"
 	"runtime"
+	"sync/atomic"
 	"time"
+
+	"k8s.io/client-go/discovery"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 )
 
 // TCPDialCheck returns a Check that checks TCP connectivity to the provided endpoint.
@@ -87,3 +91,62 @@ func GoroutineCountCheck(threshold int) Check {
 		return nil
 	}
 }
+
+// CacheSyncCheck returns a Check that fails until the informers of the cache
+// have synced. A synced cache stays synced, so it is only waited for once.
+func CacheSyncCheck(c cache.Cache, timeout time.Duration) Check {
+	var synced int32
+	return func() error {
+		if atomic.LoadInt32(&synced) == 1 {
+			return nil
+		}
+		stopCh := make(chan struct{})
+		timer := time.AfterFunc(timeout, func() { close(stopCh) })
+		defer timer.Stop()
+		if !c.WaitForCacheSync(stopCh) {
+			return fmt.Errorf("informer cache not synced")
+		}
+		atomic.StoreInt32(&synced, 1)
+		return nil
+	}
+}
+
+// APIServerCheck returns a Check that makes sure the API server answers the
+// discovery of its version.
+func APIServerCheck(client discovery.DiscoveryInterface) Check {
+	return func() error {
+		if _, err := client.ServerVersion(); err != nil {
+			return fmt.Errorf("api server unreachable: %v", err)
+		}
+		return nil
+	}
+}
+
+// ConfigLoadedCheck returns a Check that fails while one of the configs failed
+// to load, e.g. with config.Reloader.Err.
+func ConfigLoadedCheck(errFuncs ...func() error) Check {
+	return func() error {
+		for _, errFunc := range errFuncs {
+			if err := errFunc(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// LatencySLOCheck returns a Check that fails when the latency returned by the
+// latency function exceeds the SLO. It passes while fewer than minSamples
+// requests were observed.
+func LatencySLOCheck(latency func() (time.Duration, int), slo time.Duration, minSamples int) Check {
+	return func() error {
+		observed, samples := latency()
+		if samples < minSamples {
+			return nil
+		}
+		if observed > slo {
+			return fmt.Errorf("latency %v exceeds the SLO %v over %d requests", observed, slo, samples)
+		}
+		return nil
+	}
+}
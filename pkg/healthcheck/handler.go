@@ -1,25 +1,43 @@
 package healthcheck
 
 import (
+	"bytes"
+	"fmt"
 	"github.com/gin-gonic/gin"
 	"net/http"
+	"sort"
+	"strings"
 	"sync"
 	"github.com/xkcp0324/custom-scheduler/pkg/router"
 )
 
 // basicHandler is a basic Handler implementation.
 type basicHandler struct {
+	opt Options
+
 	checksMutex     sync.RWMutex
-	livenessChecks  map[string]Check
-	readinessChecks map[string]Check
+	livenessChecks  map[string]*runner
+	readinessChecks map[string]*runner
+
+	// stopCh is set once the handler is started, the checks added later start
+	// right away
+	stopCh <-chan struct{}
 }
 
 // NewHandler creates a new basic Handler
-func NewHealthHandler() Handler {
+func NewHealthHandler(opt *Options) Handler {
 	h := &basicHandler{
-		livenessChecks:  make(map[string]Check),
-		readinessChecks: make(map[string]Check),
+		opt:             *opt,
+		livenessChecks:  make(map[string]*runner),
+		readinessChecks: make(map[string]*runner),
 	}
+	h.opt.CheckOptions = withDefaults(h.opt.CheckOptions, CheckOptions{
+		Interval:         DefaultInterval,
+		Timeout:          DefaultTimeout,
+		FailureThreshold: DefaultFailureThreshold,
+		SuccessThreshold: DefaultSuccessThreshold,
+	})
+	registerMetrics()
 	return h
 }
 
@@ -27,8 +45,12 @@ func (s *basicHandler) Routes() []*router.Route {
 	var routes []*router.Route
 
 	ctlRoutes := []*router.Route{
-		{"GET", "/live", s.LiveEndpoint, ""},
-		{"GET", "/ready", s.ReadyEndpoint, ""},
+		{Method: "GET", Path: "/live", Handler: s.LiveEndpoint},
+		{Method: "GET", Path: "/ready", Handler: s.ReadyEndpoint},
+		{Method: "GET", Path: "/livez", Handler: s.livez},
+		{Method: "GET", Path: "/livez/:check", Handler: s.livezCheck},
+		{Method: "GET", Path: "/readyz", Handler: s.readyz},
+		{Method: "GET", Path: "/readyz/:check", Handler: s.readyzCheck},
 	}
 
 	routes = append(routes, ctlRoutes...)
@@ -43,33 +65,86 @@ func (s *basicHandler) ReadyEndpoint(ctx *gin.Context) {
 	s.handle(ctx, s.readinessChecks, s.livenessChecks)
 }
 
+func (s *basicHandler) livez(ctx *gin.Context) {
+	s.handleZ(ctx, "livez", s.livenessChecks)
+}
+
+func (s *basicHandler) livezCheck(ctx *gin.Context) {
+	s.handleZCheck(ctx, s.livenessChecks)
+}
+
+func (s *basicHandler) readyz(ctx *gin.Context) {
+	s.handleZ(ctx, "readyz", s.readinessChecks, s.livenessChecks)
+}
+
+func (s *basicHandler) readyzCheck(ctx *gin.Context) {
+	s.handleZCheck(ctx, s.readinessChecks, s.livenessChecks)
+}
+
 func (s *basicHandler) AddLivenessCheck(name string, check Check) {
-	s.checksMutex.Lock()
-	defer s.checksMutex.Unlock()
-	s.livenessChecks[name] = check
+	s.AddLivenessCheckWithOptions(name, check, &CheckOptions{})
 }
 
 func (s *basicHandler) AddReadinessCheck(name string, check Check) {
+	s.AddReadinessCheckWithOptions(name, check, &CheckOptions{})
+}
+
+// AddLivenessCheckWithOptions adds a liveness check, it is healthy until it
+// reaches the failure threshold
+func (s *basicHandler) AddLivenessCheckWithOptions(name string, check Check, opt *CheckOptions) {
+	s.addCheck(s.livenessChecks, name, newRunner(name, KindLiveness, check, withDefaults(*opt, s.opt.CheckOptions)))
+}
+
+// AddReadinessCheckWithOptions adds a readiness check, it is unhealthy until it
+// reaches the success threshold
+func (s *basicHandler) AddReadinessCheckWithOptions(name string, check Check, opt *CheckOptions) {
+	s.addCheck(s.readinessChecks, name, newRunner(name, KindReadiness, check, withDefaults(*opt, s.opt.CheckOptions)))
+}
+
+func (s *basicHandler) addCheck(checks map[string]*runner, name string, r *runner) {
 	s.checksMutex.Lock()
 	defer s.checksMutex.Unlock()
-	s.readinessChecks[name] = check
+	checks[name] = r
+	if s.stopCh != nil {
+		go r.start(s.stopCh)
+	}
 }
 
-func (s *basicHandler) collectChecks(checks map[string]Check, resultsOut map[string]string, statusOut *int) {
+// Start runs the checks until stopCh is closed
+func (s *basicHandler) Start(stopCh <-chan struct{}) error {
+	s.checksMutex.Lock()
+	s.stopCh = stopCh
+	for _, checks := range []map[string]*runner{s.livenessChecks, s.readinessChecks} {
+		for _, r := range checks {
+			go r.start(stopCh)
+		}
+	}
+	s.checksMutex.Unlock()
+
+	<-stopCh
+	return nil
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable, every replica
+// reports its own health
+func (s *basicHandler) NeedLeaderElection() bool {
+	return false
+}
+
+func (s *basicHandler) collectChecks(checks map[string]*runner, resultsOut map[string]Result, statusOut *int) {
 	s.checksMutex.RLock()
 	defer s.checksMutex.RUnlock()
-	for name, check := range checks {
-		if err := check(); err != nil {
+	for name, r := range checks {
+		result := r.get()
+		if !result.Healthy {
 			*statusOut = http.StatusServiceUnavailable
-			resultsOut[name] = err.Error()
-		} else {
-			resultsOut[name] = "OK"
 		}
+		resultsOut[name] = result
 	}
 }
 
-func (s *basicHandler) handle(ctx *gin.Context, checks ...map[string]Check) {
-	checkResults := make(map[string]string)
+func (s *basicHandler) handle(ctx *gin.Context, checks ...map[string]*runner) {
+	checkResults := make(map[string]Result)
 	status := http.StatusOK
 	for _, checks := range checks {
 		s.collectChecks(checks, checkResults, &status)
@@ -85,3 +160,97 @@ func (s *basicHandler) handle(ctx *gin.Context, checks ...map[string]Check) {
 
 	ctx.IndentedJSON(status, checkResults)
 }
+
+// handleZ answers as the kube-apiserver /livez and /readyz do: "ok" when every
+// check passes, a "[+]name ok" / "[-]name failed" listing with ?verbose or when
+// a check fails. The checks named by ?exclude are skipped.
+func (s *basicHandler) handleZ(ctx *gin.Context, endpoint string, checks ...map[string]*runner) {
+	excluded := make(map[string]bool)
+	for _, name := range ctx.QueryArray("exclude") {
+		excluded[name] = true
+	}
+
+	type namedResult struct {
+		name   string
+		result Result
+	}
+	results := []namedResult{}
+	s.checksMutex.RLock()
+	for _, checks := range checks {
+		for name, r := range checks {
+			if _, ok := excluded[name]; ok {
+				excluded[name] = false
+				continue
+			}
+			results = append(results, namedResult{name: name, result: r.get()})
+		}
+	}
+	s.checksMutex.RUnlock()
+	sort.Slice(results, func(i, j int) bool { return results[i].name < results[j].name })
+
+	failed := false
+	var body bytes.Buffer
+	for _, nr := range results {
+		if nr.result.Healthy {
+			fmt.Fprintf(&body, "[+]%s ok\n", nr.name)
+			continue
+		}
+		failed = true
+		// the reason is withheld as kube-apiserver does, it is in ?full=true of the legacy endpoints
+		fmt.Fprintf(&body, "[-]%s failed: reason withheld\n", nr.name)
+	}
+
+	unknown := []string{}
+	for name, notFound := range excluded {
+		if notFound {
+			unknown = append(unknown, fmt.Sprintf("%q", name))
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		fmt.Fprintf(&body, "warn: some health checks cannot be excluded: no matches for %s\n", strings.Join(unknown, ", "))
+	}
+
+	ctx.Header("X-Content-Type-Options", "nosniff")
+	if failed {
+		fmt.Fprintf(&body, "%s check failed\n", endpoint)
+		ctx.Data(http.StatusInternalServerError, "text/plain; charset=utf-8", body.Bytes())
+		return
+	}
+
+	if _, verbose := ctx.GetQuery("verbose"); !verbose {
+		ctx.Data(http.StatusOK, "text/plain; charset=utf-8", []byte("ok"))
+		return
+	}
+	fmt.Fprintf(&body, "%s check passed\n", endpoint)
+	ctx.Data(http.StatusOK, "text/plain; charset=utf-8", body.Bytes())
+}
+
+// handleZCheck answers the /livez/<check> and /readyz/<check> of a single check
+func (s *basicHandler) handleZCheck(ctx *gin.Context, checks ...map[string]*runner) {
+	name := ctx.Param("check")
+
+	s.checksMutex.RLock()
+	var r *runner
+	for _, checks := range checks {
+		if found, ok := checks[name]; ok {
+			r = found
+			break
+		}
+	}
+	s.checksMutex.RUnlock()
+
+	ctx.Header("X-Content-Type-Options", "nosniff")
+	if r == nil {
+		ctx.Data(http.StatusNotFound, "text/plain; charset=utf-8", []byte(fmt.Sprintf("check %q not found\n", name)))
+		return
+	}
+
+	result := r.get()
+	if !result.Healthy {
+		ctx.Data(http.StatusInternalServerError, "text/plain; charset=utf-8",
+			[]byte(fmt.Sprintf("internal server error: %s\n", result.Error)))
+		return
+	}
+	ctx.Data(http.StatusOK, "text/plain; charset=utf-8", []byte("ok"))
+}
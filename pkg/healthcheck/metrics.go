@@ -0,0 +1,49 @@
+package healthcheck
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/xkcp0324/custom-scheduler/pkg/router/ginprom"
+	"k8s.io/klog"
+)
+
+// The kinds of a check
+const (
+	KindLiveness  = "liveness"
+	KindReadiness = "readiness"
+)
+
+var checkStatus = &ginprom.Metric{
+	ID:          "checkStatus",
+	Name:        "health_check_status",
+	Description: "Whether the health check is healthy (1) or not (0), partitioned by check and kind.",
+	Type:        "gauge_vec",
+	Args:        []string{"check", "kind"}}
+
+var registerOnce sync.Once
+
+// registerMetrics registers the gauges without a subsystem, so they keep the
+// health_check_status name whatever the binary embedding the handler
+func registerMetrics() {
+	registerOnce.Do(func() {
+		metric := ginprom.NewMetric(checkStatus, "")
+		if err := prometheus.Register(metric); err != nil {
+			klog.Infof("%s could not be registered: %v", checkStatus.Name, err)
+			return
+		}
+		checkStatus.MetricCollector = metric
+	})
+}
+
+func observeStatus(check, kind string, healthy bool) {
+	g, ok := checkStatus.MetricCollector.(*prometheus.GaugeVec)
+	if !ok {
+		return
+	}
+	value := 0.0
+	if healthy {
+		value = 1
+	}
+	g.WithLabelValues(check, kind).Set(value)
+}
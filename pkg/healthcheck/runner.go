@@ -0,0 +1,146 @@
+package healthcheck
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"k8s.io/klog"
+)
+
+// runner runs a check on its interval and caches its result
+type runner struct {
+	name  string
+	kind  string
+	check Check
+	opt   CheckOptions
+
+	lock   sync.RWMutex
+	result Result
+
+	// running is set while a run of the check has not returned, a run which
+	// timed out is not started again until it returns
+	running bool
+}
+
+// newRunner returns the runner of a check, a liveness check is healthy and a
+// readiness check unhealthy until they reach their thresholds
+func newRunner(name, kind string, check Check, opt CheckOptions) *runner {
+	r := &runner{
+		name:  name,
+		kind:  kind,
+		check: check,
+		opt:   opt,
+	}
+	r.result.Healthy = kind == KindLiveness
+	if !r.result.Healthy {
+		r.result.Error = "not run yet"
+	}
+	observeStatus(name, kind, r.result.Healthy)
+	return r
+}
+
+// withDefaults fills the zero options with the defaults
+func withDefaults(opt, defaults CheckOptions) CheckOptions {
+	if opt.Interval <= 0 {
+		opt.Interval = defaults.Interval
+	}
+	if opt.Timeout <= 0 {
+		opt.Timeout = defaults.Timeout
+	}
+	if opt.FailureThreshold <= 0 {
+		opt.FailureThreshold = defaults.FailureThreshold
+	}
+	if opt.SuccessThreshold <= 0 {
+		opt.SuccessThreshold = defaults.SuccessThreshold
+	}
+	return opt
+}
+
+// get returns the cached result
+func (r *runner) get() Result {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.result
+}
+
+// start runs the check right away, then on its interval until stopCh is closed
+func (r *runner) start(stopCh <-chan struct{}) {
+	ticker := time.NewTicker(r.opt.Interval)
+	defer ticker.Stop()
+
+	for {
+		r.run()
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// run runs the check once with the timeout and records the result
+func (r *runner) run() {
+	r.lock.Lock()
+	if r.running {
+		r.lock.Unlock()
+		r.record(time.Now(), 0, fmt.Errorf("previous run has not returned"))
+		return
+	}
+	r.running = true
+	r.lock.Unlock()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				done <- fmt.Errorf("panic: %v", p)
+			}
+			r.lock.Lock()
+			r.running = false
+			r.lock.Unlock()
+		}()
+		done <- r.check()
+	}()
+
+	timer := time.NewTimer(r.opt.Timeout)
+	defer timer.Stop()
+
+	select {
+	case err := <-done:
+		r.record(start, time.Since(start), err)
+	case <-timer.C:
+		r.record(start, time.Since(start), fmt.Errorf("timed out after %v", r.opt.Timeout))
+	}
+}
+
+// record updates the result, the check only changes its state once it reached
+// the failure or success threshold
+func (r *runner) record(start time.Time, duration time.Duration, err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	defer func() { observeStatus(r.name, r.kind, r.result.Healthy) }()
+
+	res := &r.result
+	res.LastRun = start
+	res.Duration = duration
+	if err != nil {
+		res.Error = err.Error()
+		res.ConsecutiveFailures++
+		res.ConsecutiveSuccesses = 0
+		if res.Healthy && res.ConsecutiveFailures >= r.opt.FailureThreshold {
+			klog.Warningf("health check %s failed %d times: %v", r.name, res.ConsecutiveFailures, err)
+			res.Healthy = false
+		}
+		return
+	}
+
+	res.Error = ""
+	res.ConsecutiveSuccesses++
+	res.ConsecutiveFailures = 0
+	if !res.Healthy && res.ConsecutiveSuccesses >= r.opt.SuccessThreshold {
+		klog.Infof("health check %s recovered", r.name)
+		res.Healthy = true
+	}
+}
@@ -1,6 +1,8 @@
 package healthcheck
 
 import (
+	"time"
+
 	"github.com/gin-gonic/gin"
 	"github.com/xkcp0324/custom-scheduler/pkg/router"
 )
@@ -8,13 +10,67 @@ import (
 // Check is a health/readiness check.
 type Check func() error
 
+// CheckOptions are the options of running a check, a zero value takes the
+// default of the handler
+type CheckOptions struct {
+	// Interval is the time between two runs of the check
+	Interval time.Duration
+
+	// Timeout fails a run of the check which did not return in time
+	Timeout time.Duration
+
+	// FailureThreshold is how many consecutive failures turn a healthy check
+	// unhealthy, and SuccessThreshold how many consecutive successes turn it
+	// healthy again
+	FailureThreshold int
+	SuccessThreshold int
+}
+
+// Options are options for constructing a Handler
+type Options struct {
+	// CheckOptions are the defaults of the checks registered without options
+	CheckOptions
+}
+
+// The defaults of the check options
+const (
+	DefaultInterval         = 10 * time.Second
+	DefaultTimeout          = 5 * time.Second
+	DefaultFailureThreshold = 3
+	DefaultSuccessThreshold = 1
+)
+
+// Result is the cached result of the last runs of a check
+type Result struct {
+	Healthy bool `json:"healthy"`
+
+	// Error is the error of the last run, empty when it succeeded
+	Error string `json:"error,omitempty"`
+
+	LastRun  time.Time     `json:"lastRun"`
+	Duration time.Duration `json:"duration"`
+
+	ConsecutiveFailures  int `json:"consecutiveFailures"`
+	ConsecutiveSuccesses int `json:"consecutiveSuccesses"`
+}
+
 // Handler is an endpoints with additional methods that register health and
 // readiness checks. It handles handle "/live" and "/ready" HTTP
-// endpoints.
+// endpoints, and the kube-apiserver style "/livez" and "/readyz" with
+// "/readyz/<check>", "?verbose" and "?exclude=<check>".
+//
+// The checks run in the background once the Handler is started, the endpoints
+// only read their cached results.
 type Handler interface {
 	Routes() []*router.Route
 	AddLivenessCheck(name string, check Check)
 	AddReadinessCheck(name string, check Check)
+	AddLivenessCheckWithOptions(name string, check Check, opt *CheckOptions)
+	AddReadinessCheckWithOptions(name string, check Check, opt *CheckOptions)
 	LiveEndpoint(ctx *gin.Context)
 	ReadyEndpoint(ctx *gin.Context)
+
+	// Start runs the checks until stopCh is closed, it implements manager.Runnable
+	Start(stopCh <-chan struct{}) error
+	NeedLeaderElection() bool
 }
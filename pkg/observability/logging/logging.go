@@ -0,0 +1,54 @@
+package logging
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/go-logr/logr"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// The log formats
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+type loggerKey struct{}
+
+// IntoContext returns a copy of ctx carrying the logger
+func IntoContext(ctx context.Context, logger logr.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// FromContext returns the logger of ctx, the controller-runtime logger when ctx
+// carries none
+func FromContext(ctx context.Context) logr.Logger {
+	if ctx != nil {
+		if logger, ok := ctx.Value(loggerKey{}).(logr.Logger); ok {
+			return logger
+		}
+	}
+	return logf.Log
+}
+
+// Sampler keeps one of every n events, it is meant for the logs too verbose to
+// be written for every request
+type Sampler struct {
+	every int64
+	count int64
+}
+
+// NewSampler returns a Sampler keeping one of every n events, every event is
+// kept when n is below 2
+func NewSampler(n int) *Sampler {
+	return &Sampler{every: int64(n)}
+}
+
+// Allow returns whether the event is kept
+func (s *Sampler) Allow() bool {
+	if s == nil || s.every <= 1 {
+		return true
+	}
+	return (atomic.AddInt64(&s.count, 1)-1)%s.every == 0
+}
@@ -0,0 +1,87 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// propagator reads and writes the W3C traceparent header
+var propagator = propagation.TraceContext{}
+
+// Span is a timed operation of a trace. A nil Span is valid and records nothing,
+// so the callers do not have to check whether the request is traced.
+type Span struct {
+	span trace.Span
+}
+
+// SetAttribute sets an attribute of the span
+func (s *Span) SetAttribute(key, value string) {
+	if s == nil {
+		return
+	}
+	s.span.SetAttributes(attribute.String(key, value))
+}
+
+// SetName renames the span
+func (s *Span) SetName(name string) {
+	if s == nil {
+		return
+	}
+	s.span.SetName(name)
+}
+
+// SetError marks the span as failed, a nil error is ignored
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.span.RecordError(err)
+	s.span.SetStatus(codes.Error, err.Error())
+}
+
+// Finish ends the span, the SDK exports it when it is sampled
+func (s *Span) Finish() {
+	if s == nil {
+		return
+	}
+	s.span.End()
+}
+
+// SpanFromContext returns the span of ctx, nil when the request is not traced
+func SpanFromContext(ctx context.Context) *Span {
+	if ctx == nil {
+		return nil
+	}
+	span := trace.SpanFromContext(ctx)
+	if !span.IsRecording() {
+		return nil
+	}
+	return &Span{span: span}
+}
+
+// StartSpan starts a child of the span of ctx. It returns a nil span and ctx
+// unchanged when the request is not traced.
+func StartSpan(ctx context.Context, name string) (context.Context, *Span) {
+	parent := SpanFromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+	ctx, span := parent.span.TracerProvider().Tracer(instrumentationName).Start(ctx, name)
+	return ctx, &Span{span: span}
+}
+
+// Extract returns ctx with the remote parent of the traceparent header, ctx
+// unchanged when the header is missing or invalid
+func Extract(ctx context.Context, header http.Header) context.Context {
+	return propagator.Extract(ctx, propagation.HeaderCarrier(header))
+}
+
+// Inject writes the traceparent header of the span of ctx
+func Inject(ctx context.Context, header http.Header) {
+	propagator.Inject(ctx, propagation.HeaderCarrier(header))
+}
@@ -0,0 +1,136 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+	"go.opentelemetry.io/otel/trace"
+	"k8s.io/klog"
+)
+
+// The exporters of the spans
+const (
+	ExporterOTLP   = "otlp"
+	ExporterStdout = "stdout"
+)
+
+const (
+	// DefaultOTLPEndpoint is the default OTLP/HTTP traces endpoint of a local collector
+	DefaultOTLPEndpoint = "http://localhost:4318/v1/traces"
+
+	// DefaultFlushInterval is the default max delay before the spans are exported
+	DefaultFlushInterval = 5 * time.Second
+
+	// instrumentationName names the tracer of the spans of this module
+	instrumentationName = "github.com/xkcp0324/custom-scheduler"
+)
+
+// Options are options for constructing a Tracer
+type Options struct {
+	// ServiceName is the service.name resource attribute
+	ServiceName string
+
+	// Exporter is either ExporterOTLP or ExporterStdout
+	Exporter string
+
+	// Endpoint is the OTLP/HTTP traces endpoint
+	Endpoint string
+
+	FlushInterval time.Duration
+}
+
+// Tracer starts the root spans of the requests, the OpenTelemetry SDK exports
+// the finished spans in batches. It is a manager.Runnable which runs on every
+// replica and flushes the spans left when it stops.
+type Tracer struct {
+	provider      *sdktrace.TracerProvider
+	tracer        trace.Tracer
+	flushInterval time.Duration
+}
+
+// NewTracer returns a Tracer exporting to the exporter of the options
+func NewTracer(opt *Options) (*Tracer, error) {
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch opt.Exporter {
+	case ExporterOTLP:
+		endpoint := opt.Endpoint
+		if endpoint == "" {
+			endpoint = DefaultOTLPEndpoint
+		}
+		exporter, err = newOTLPExporter(endpoint)
+	case ExporterStdout:
+		exporter, err = stdouttrace.New()
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q", opt.Exporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	interval := opt.FlushInterval
+	if interval <= 0 {
+		interval = DefaultFlushInterval
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter, sdktrace.WithBatchTimeout(interval)),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL,
+			semconv.ServiceNameKey.String(opt.ServiceName))),
+	)
+	return &Tracer{
+		provider:      provider,
+		tracer:        provider.Tracer(instrumentationName),
+		flushInterval: interval,
+	}, nil
+}
+
+// newOTLPExporter returns an OTLP/HTTP exporter posting to the endpoint URL
+func newOTLPExporter(endpoint string) (sdktrace.SpanExporter, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid trace endpoint %q", endpoint)
+	}
+
+	opts := []otlptracehttp.Option{
+		otlptracehttp.WithEndpoint(u.Host),
+		otlptracehttp.WithTimeout(10 * time.Second),
+	}
+	if u.Path != "" {
+		opts = append(opts, otlptracehttp.WithURLPath(u.Path))
+	}
+	if u.Scheme == "http" {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	return otlptracehttp.New(context.Background(), opts...)
+}
+
+// StartRootSpan starts the first span of a request in this process, a child of
+// the remote parent extracted into ctx when there is one
+func (t *Tracer) StartRootSpan(ctx context.Context, name string) (context.Context, *Span) {
+	ctx, span := t.tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindServer))
+	return ctx, &Span{span: span}
+}
+
+// Start waits until stopCh is closed, then flushes the queued spans
+func (t *Tracer) Start(stopCh <-chan struct{}) error {
+	<-stopCh
+
+	ctx, cancel := context.WithTimeout(context.Background(), t.flushInterval)
+	defer cancel()
+	if err := t.provider.Shutdown(ctx); err != nil {
+		klog.Errorf("shutdown tracer err: %+v", err)
+	}
+	return nil
+}
+
+// NeedLeaderElection returns false, every replica traces the requests it serves
+func (t *Tracer) NeedLeaderElection() bool {
+	return false
+}
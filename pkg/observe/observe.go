@@ -3,4 +3,16 @@ package observe
 
 const (
     ObserveMustLabelAppName     = "app"
-)
\ No newline at end of file
+
+    // ObserveAnnotationCoLocateWith lists the apps a pod prefers to run next to, separated by commas
+    ObserveAnnotationCoLocateWith = "custom-scheduler/colocate-with"
+
+    // ObserveLabelNodePrice is the default node label holding the price of the node
+    ObserveLabelNodePrice = "custom-scheduler/price"
+
+    // ObserveAnnotationTerminationNotice marks a node which is about to be terminated
+    ObserveAnnotationTerminationNotice = "custom-scheduler/termination-notice"
+
+    // ObserveAnnotationBreakGlass set to "true" lets a pod ignore the tenant pools
+    ObserveAnnotationBreakGlass = "custom-scheduler/break-glass"
+)
@@ -0,0 +1,296 @@
+package rebalancer
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/xkcp0324/custom-scheduler/pkg/observe"
+	"github.com/xkcp0324/custom-scheduler/pkg/scheduler/predicates"
+	corev1 "k8s.io/api/core/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/flowcontrol"
+	"k8s.io/klog"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// Options are options for constructing a Rebalancer
+type Options struct {
+	// Interval between two rebalance rounds
+	Interval time.Duration
+
+	// MaxSkew is the max difference of HA score between the nodes of an app
+	MaxSkew int
+
+	// EvictionQPS and EvictionBurst limit the rate of evictions
+	EvictionQPS   float32
+	EvictionBurst int
+
+	// DryRun only reports the pods which would be evicted
+	DryRun bool
+}
+
+// Rebalancer periodically evicts the surplus replicas of the apps whose
+// distribution drifted away from what the HighAvailability predicate prefers.
+// It only runs on the leader.
+type Rebalancer struct {
+	kubeCli kubernetes.Interface
+	mgr     manager.Manager
+	index   *predicates.ReplicaIndex
+	opt     Options
+	limiter flowcontrol.RateLimiter
+}
+
+// workload is the key of the replicas of an app
+type workload struct {
+	namespace    string
+	instanceName string
+}
+
+// NewRebalancer returns a Rebalancer
+func NewRebalancer(kubeCli kubernetes.Interface, mgr manager.Manager, opt *Options) *Rebalancer {
+	o := *opt
+	if o.Interval <= 0 {
+		o.Interval = 5 * time.Minute
+	}
+	if o.MaxSkew <= 0 {
+		o.MaxSkew = 1
+	}
+	if o.EvictionQPS <= 0 {
+		o.EvictionQPS = 0.1
+	}
+	if o.EvictionBurst <= 0 {
+		o.EvictionBurst = 1
+	}
+
+	return &Rebalancer{
+		kubeCli: kubeCli,
+		mgr:     mgr,
+		index:   predicates.NewReplicaIndex(mgr, nil),
+		opt:     o,
+		limiter: flowcontrol.NewTokenBucketRateLimiter(o.EvictionQPS, o.EvictionBurst),
+	}
+}
+
+// Start runs a rebalance round every interval until the stop channel is closed
+func (r *Rebalancer) Start(stopCh <-chan struct{}) error {
+	klog.Infof("start rebalancer interval: %v max skew: %d dry run: %v", r.opt.Interval, r.opt.MaxSkew, r.opt.DryRun)
+	wait.Until(r.rebalance, r.opt.Interval, stopCh)
+	return nil
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable, only the leader
+// evicts pods
+func (r *Rebalancer) NeedLeaderElection() bool {
+	return true
+}
+
+func (r *Rebalancer) rebalance() {
+	cl := r.mgr.GetClient()
+
+	nodeList := &corev1.NodeList{}
+	if err := cl.List(context.Background(), nodeList); err != nil {
+		klog.Errorf("list node err: %+v", err)
+		return
+	}
+
+	podList := &corev1.PodList{}
+	if err := cl.List(context.Background(), podList); err != nil {
+		klog.Errorf("list pod err: %+v", err)
+		return
+	}
+
+	workloads := make(map[workload][]corev1.Pod)
+	for _, pod := range podList.Items {
+		instanceName, ok := pod.Labels[observe.ObserveMustLabelAppName]
+		if !ok || !isEvictable(&pod) {
+			continue
+		}
+		key := workload{namespace: pod.Namespace, instanceName: instanceName}
+		workloads[key] = append(workloads[key], pod)
+	}
+
+	keys := make([]workload, 0, len(workloads))
+	for key := range workloads {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].namespace != keys[j].namespace {
+			return keys[i].namespace < keys[j].namespace
+		}
+		return keys[i].instanceName < keys[j].instanceName
+	})
+
+	for _, key := range keys {
+		if !r.rebalanceWorkload(key, workloads[key], nodeList.Items) {
+			return
+		}
+	}
+}
+
+// rebalanceWorkload evicts at most one replica of the workload, it returns false
+// when the eviction rate limit is reached
+func (r *Rebalancer) rebalanceWorkload(key workload, pods []corev1.Pod, nodes []corev1.Node) bool {
+	replicas, err := r.index.ReplicasByNode(context.Background(), key.namespace, key.instanceName, "")
+	if err != nil {
+		klog.Errorf("list pod err: %+v", err)
+		return true
+	}
+
+	// the best score a replica could get on the nodes fitting the workload
+	best := -1
+	for i := range nodes {
+		if fitsNode(&pods[0], &nodes[i]) {
+			if score := predicates.HAScore(replicas[nodes[i].Name]); score > best {
+				best = score
+			}
+		}
+	}
+	if best < 0 {
+		return true
+	}
+
+	// the worst node currently running a replica
+	worstNode, worst := "", best
+	for nodeName, count := range replicas {
+		if score := predicates.HAScore(count); score < worst || score == worst && nodeName < worstNode {
+			worstNode, worst = nodeName, score
+		}
+	}
+
+	skew := best - worst
+	if skew <= r.opt.MaxSkew {
+		return true
+	}
+
+	victim := r.pickVictim(pods, worstNode)
+	if victim == nil {
+		klog.V(3).Infof("app %s/%s skew %d: no evictable replica on node %s", key.namespace, key.instanceName, skew, worstNode)
+		return true
+	}
+
+	if !r.limiter.TryAccept() {
+		klog.V(3).Infof("eviction rate limit reached, rebalance stopped at app %s/%s", key.namespace, key.instanceName)
+		return false
+	}
+
+	if r.opt.DryRun {
+		klog.Infof("dry run: would evict pod %s/%s on node %s, app %s skew %d", victim.Namespace, victim.Name, worstNode, key.instanceName, skew)
+		return true
+	}
+
+	klog.Infof("evict pod %s/%s on node %s, app %s skew %d", victim.Namespace, victim.Name, worstNode, key.instanceName, skew)
+	eviction := &policyv1beta1.Eviction{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      victim.Name,
+			Namespace: victim.Namespace,
+		},
+	}
+	if err := r.kubeCli.PolicyV1beta1().Evictions(victim.Namespace).Evict(eviction); err != nil {
+		if errors.IsTooManyRequests(err) {
+			klog.V(3).Infof("eviction of pod %s/%s is refused by its disruption budget", victim.Namespace, victim.Name)
+		} else {
+			klog.Errorf("evict pod %s/%s err: %+v", victim.Namespace, victim.Name, err)
+		}
+	}
+	return true
+}
+
+// pickVictim returns the youngest replica on the node whose disruption budgets
+// allow an eviction
+func (r *Rebalancer) pickVictim(pods []corev1.Pod, nodeName string) *corev1.Pod {
+	var candidates []*corev1.Pod
+	for i := range pods {
+		if pods[i].Spec.NodeName == nodeName {
+			candidates = append(candidates, &pods[i])
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[j].CreationTimestamp.Before(&candidates[i].CreationTimestamp)
+	})
+
+	for _, pod := range candidates {
+		allowed, err := r.disruptionAllowed(pod)
+		if err != nil {
+			klog.Errorf("list pdb err: %+v", err)
+			return nil
+		}
+		if allowed {
+			return pod
+		}
+	}
+	return nil
+}
+
+// disruptionAllowed checks the disruption budgets of the pod in the cache, the
+// Eviction API checks them again when the pod is evicted
+func (r *Rebalancer) disruptionAllowed(pod *corev1.Pod) (bool, error) {
+	pdbList := &policyv1beta1.PodDisruptionBudgetList{}
+	if err := r.mgr.GetClient().List(context.Background(), pdbList, client.InNamespace(pod.Namespace)); err != nil {
+		return false, err
+	}
+
+	for _, pdb := range pdbList.Items {
+		selector, err := metav1.LabelSelectorAsSelector(pdb.Spec.Selector)
+		if err != nil || selector.Empty() || !selector.Matches(labels.Set(pod.Labels)) {
+			continue
+		}
+		if pdb.Status.PodDisruptionsAllowed < 1 {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// isEvictable returns whether the pod is a running replica managed by a controller
+func isEvictable(pod *corev1.Pod) bool {
+	if pod.Spec.NodeName == "" || pod.DeletionTimestamp != nil || pod.Status.Phase != corev1.PodRunning {
+		return false
+	}
+	return metav1.GetControllerOf(pod) != nil
+}
+
+// fitsNode returns whether a replica of the pod could run on the node, it checks
+// the node conditions, the node selector and the taints
+func fitsNode(pod *corev1.Pod, node *corev1.Node) bool {
+	if node.Spec.Unschedulable || !isNodeReady(node) {
+		return false
+	}
+
+	if !labels.SelectorFromSet(pod.Spec.NodeSelector).Matches(labels.Set(node.Labels)) {
+		return false
+	}
+
+	for i := range node.Spec.Taints {
+		taint := &node.Spec.Taints[i]
+		if taint.Effect == corev1.TaintEffectPreferNoSchedule {
+			continue
+		}
+		tolerated := false
+		for j := range pod.Spec.Tolerations {
+			if pod.Spec.Tolerations[j].ToleratesTaint(taint) {
+				tolerated = true
+				break
+			}
+		}
+		if !tolerated {
+			return false
+		}
+	}
+	return true
+}
+
+func isNodeReady(node *corev1.Node) bool {
+	for _, cond := range node.Status.Conditions {
+		if cond.Type == corev1.NodeReady {
+			return cond.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
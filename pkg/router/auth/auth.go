@@ -0,0 +1,283 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog"
+)
+
+const (
+	// DefaultCacheTTL is how long an allowed or authenticated result is cached
+	DefaultCacheTTL = 2 * time.Minute
+
+	// DefaultDeniedCacheTTL is how long a denied or unauthenticated result is cached
+	DefaultDeniedCacheTTL = 10 * time.Second
+
+	// maxCacheEntries triggers the cleanup of the expired entries
+	maxCacheEntries = 4096
+)
+
+// Options are options for constructing an Authorizer
+type Options struct {
+	// ResourceAttributes are checked in the SubjectAccessReview with the verb of
+	// the request. The path and the verb are checked as non-resource attributes
+	// when it is nil.
+	ResourceAttributes *authorizationv1.ResourceAttributes
+
+	CacheTTL       time.Duration
+	DeniedCacheTTL time.Duration
+}
+
+// Authorizer authenticates the bearer tokens with the TokenReview API and
+// authorizes the users with the SubjectAccessReview API, as kube-rbac-proxy does
+type Authorizer struct {
+	kubeCli kubernetes.Interface
+	opt     Options
+
+	lock  sync.Mutex
+	users map[string]userEntry
+	// decisions is user/attributes => allowed
+	decisions map[string]decisionEntry
+}
+
+type userEntry struct {
+	user    *authenticationv1.UserInfo
+	expires time.Time
+}
+
+type decisionEntry struct {
+	allowed bool
+	reason  string
+	expires time.Time
+}
+
+// NewAuthorizer returns an Authorizer reviewing the requests through the apiserver
+func NewAuthorizer(kubeCli kubernetes.Interface, opt *Options) *Authorizer {
+	a := &Authorizer{
+		kubeCli:   kubeCli,
+		opt:       *opt,
+		users:     make(map[string]userEntry),
+		decisions: make(map[string]decisionEntry),
+	}
+	if a.opt.CacheTTL <= 0 {
+		a.opt.CacheTTL = DefaultCacheTTL
+	}
+	if a.opt.DeniedCacheTTL <= 0 {
+		a.opt.DeniedCacheTTL = DefaultDeniedCacheTTL
+	}
+	return a
+}
+
+// Middleware returns a gin middleware rejecting the requests without a valid
+// bearer token with 401, and the requests the user may not make with 403
+func (a *Authorizer) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := bearerToken(c.GetHeader("Authorization"))
+		if token == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "bearer token required"})
+			return
+		}
+
+		user, err := a.authenticate(token)
+		if err != nil {
+			klog.V(3).Infof("authenticate %s %s err: %v", c.Request.Method, c.Request.URL.Path, err)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		allowed, reason, err := a.authorize(user, verbOf(c.Request.Method), c.Request.URL.Path)
+		if err != nil {
+			klog.Errorf("authorize %s err: %+v", user.Username, err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "authorization failed"})
+			return
+		}
+		if !allowed {
+			klog.V(3).Infof("%s is not allowed to %s %s: %s", user.Username, c.Request.Method, c.Request.URL.Path, reason)
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+			return
+		}
+
+		c.Set("user", user.Username)
+	}
+}
+
+func bearerToken(header string) string {
+	parts := strings.SplitN(header, " ", 2)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "bearer") {
+		return ""
+	}
+	return strings.TrimSpace(parts[1])
+}
+
+// verbOf maps the http method to the verb of the SubjectAccessReview
+func verbOf(method string) string {
+	switch method {
+	case http.MethodPost:
+		return "create"
+	case http.MethodPut:
+		return "update"
+	case http.MethodPatch:
+		return "patch"
+	case http.MethodDelete:
+		return "delete"
+	default:
+		return "get"
+	}
+}
+
+// authenticate returns the user of the token, the token itself is never cached
+func (a *Authorizer) authenticate(token string) (*authenticationv1.UserInfo, error) {
+	sum := sha256.Sum256([]byte(token))
+	key := hex.EncodeToString(sum[:])
+
+	now := time.Now()
+	a.lock.Lock()
+	if entry, ok := a.users[key]; ok && now.Before(entry.expires) {
+		a.lock.Unlock()
+		if entry.user == nil {
+			return nil, fmt.Errorf("token is not authenticated")
+		}
+		return entry.user, nil
+	}
+	a.lock.Unlock()
+
+	review, err := a.kubeCli.AuthenticationV1().TokenReviews().Create(&authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{Token: token},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	entry := userEntry{expires: now.Add(a.opt.CacheTTL)}
+	if review.Status.Authenticated {
+		entry.user = &review.Status.User
+	} else {
+		entry.expires = now.Add(a.opt.DeniedCacheTTL)
+	}
+
+	a.lock.Lock()
+	if len(a.users) >= maxCacheEntries {
+		for k, e := range a.users {
+			if now.After(e.expires) {
+				delete(a.users, k)
+			}
+		}
+	}
+	a.users[key] = entry
+	a.lock.Unlock()
+
+	if entry.user == nil {
+		return nil, fmt.Errorf("token is not authenticated: %s", review.Status.Error)
+	}
+	return entry.user, nil
+}
+
+// authorize returns whether the user may make the request
+func (a *Authorizer) authorize(user *authenticationv1.UserInfo, verb, path string) (bool, string, error) {
+	spec := authorizationv1.SubjectAccessReviewSpec{
+		User:   user.Username,
+		UID:    user.UID,
+		Groups: user.Groups,
+	}
+	if len(user.Extra) > 0 {
+		spec.Extra = make(map[string]authorizationv1.ExtraValue, len(user.Extra))
+		for k, v := range user.Extra {
+			spec.Extra[k] = authorizationv1.ExtraValue(v)
+		}
+	}
+
+	var key string
+	if a.opt.ResourceAttributes != nil {
+		attrs := *a.opt.ResourceAttributes
+		attrs.Verb = verb
+		spec.ResourceAttributes = &attrs
+		key = fmt.Sprintf("%s/%s/%s", user.UID, user.Username, verb)
+	} else {
+		spec.NonResourceAttributes = &authorizationv1.NonResourceAttributes{Path: path, Verb: verb}
+		key = fmt.Sprintf("%s/%s/%s/%s", user.UID, user.Username, verb, path)
+	}
+
+	now := time.Now()
+	a.lock.Lock()
+	if entry, ok := a.decisions[key]; ok && now.Before(entry.expires) {
+		a.lock.Unlock()
+		return entry.allowed, entry.reason, nil
+	}
+	a.lock.Unlock()
+
+	review, err := a.kubeCli.AuthorizationV1().SubjectAccessReviews().Create(&authorizationv1.SubjectAccessReview{
+		Spec: spec,
+	})
+	if err != nil {
+		return false, "", err
+	}
+
+	entry := decisionEntry{
+		allowed: review.Status.Allowed,
+		reason:  review.Status.Reason,
+		expires: now.Add(a.opt.CacheTTL),
+	}
+	if !entry.allowed {
+		entry.expires = now.Add(a.opt.DeniedCacheTTL)
+	}
+
+	a.lock.Lock()
+	if len(a.decisions) >= maxCacheEntries {
+		for k, e := range a.decisions {
+			if now.After(e.expires) {
+				delete(a.decisions, k)
+			}
+		}
+	}
+	a.decisions[key] = entry
+	a.lock.Unlock()
+
+	return entry.allowed, entry.reason, nil
+}
+
+// ParseResourceAttributes parses attributes of the form
+// namespace=kube-system,apiGroup=,resource=services,subresource=proxy,name=custom-scheduler
+func ParseResourceAttributes(value string) (*authorizationv1.ResourceAttributes, error) {
+	if strings.TrimSpace(value) == "" {
+		return nil, nil
+	}
+
+	attrs := &authorizationv1.ResourceAttributes{}
+	for _, pair := range strings.Split(value, ",") {
+		kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid resource attribute %q", pair)
+		}
+		v := strings.TrimSpace(kv[1])
+		switch strings.TrimSpace(kv[0]) {
+		case "namespace":
+			attrs.Namespace = v
+		case "apiGroup":
+			attrs.Group = v
+		case "apiVersion":
+			attrs.Version = v
+		case "resource":
+			attrs.Resource = v
+		case "subresource":
+			attrs.Subresource = v
+		case "name":
+			attrs.Name = v
+		default:
+			return nil, fmt.Errorf("unknown resource attribute %q", kv[0])
+		}
+	}
+	if attrs.Resource == "" {
+		return nil, fmt.Errorf("resource attributes require a resource")
+	}
+	return attrs, nil
+}
@@ -83,6 +83,9 @@ type Metric struct {
 	Description     string
 	Type            string
 	Args            []string
+
+	// Buckets of the histograms, the prometheus default buckets when empty
+	Buckets []float64
 }
 
 // Prometheus contains the metrics gathered by the instance and its path
@@ -195,17 +198,6 @@ func (p *Prometheus) setMetricsPath(e *gin.Engine, metricsPath string) {
 	}
 }
 
-func (p *Prometheus) setMetricsPathWithAuth(e *gin.Engine, accounts gin.Accounts, metricsPath string) {
-
-	if p.listenAddress != "" {
-		p.router.GET(metricsPath, gin.BasicAuth(accounts), prometheusHandler())
-		p.runServer()
-	} else {
-		e.GET(metricsPath, gin.BasicAuth(accounts), prometheusHandler())
-	}
-
-}
-
 func (p *Prometheus) runServer() {
 	if p.listenAddress != "" {
 		go p.router.Run(p.listenAddress)
@@ -291,6 +283,7 @@ func NewMetric(m *Metric, subsystem string) prometheus.Collector {
 				Subsystem: subsystem,
 				Name:      m.Name,
 				Help:      m.Description,
+				Buckets:   m.Buckets,
 			},
 			m.Args,
 		)
@@ -300,6 +293,7 @@ func NewMetric(m *Metric, subsystem string) prometheus.Collector {
 				Subsystem: subsystem,
 				Name:      m.Name,
 				Help:      m.Description,
+				Buckets:   m.Buckets,
 			},
 		)
 	case "summary_vec":
@@ -360,10 +354,15 @@ func (p *Prometheus) setMetricsPathWithCustomMetrics(e *gin.Engine, gatherer pro
 	p.router.GET(metricsPath, prometheusHandlerFor(gatherer))
 }
 
-// UseWithAuth adds the middleware to a gin engine with BasicAuth.
-func (p *Prometheus) UseWithAuth(e *gin.Engine, accounts gin.Accounts, metricsPath string) {
+// UseMiddleware only records the requests of a gin engine, when the metrics are
+// served by another engine.
+func (p *Prometheus) UseMiddleware(e *gin.Engine, metricsPath string) {
 	e.Use(p.handlerFunc(metricsPath))
-	p.setMetricsPathWithAuth(e, accounts, metricsPath)
+}
+
+// UseMetricsPath only serves the metrics on a gin engine.
+func (p *Prometheus) UseMetricsPath(e *gin.Engine, metricsPath string) {
+	p.setMetricsPath(e, metricsPath)
 }
 
 func (p *Prometheus) handlerFunc(metricsPath string) gin.HandlerFunc {
@@ -0,0 +1,293 @@
+package limits
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/xkcp0324/custom-scheduler/pkg/router/ginprom"
+	"k8s.io/client-go/util/flowcontrol"
+	"k8s.io/klog"
+)
+
+// The limiters which reject a request
+const (
+	LimiterBodySize    = "body_size"
+	LimiterRate        = "rate"
+	LimiterConcurrency = "concurrency"
+)
+
+const (
+	// DefaultQueueTimeout is how long a request waits for a free slot
+	DefaultQueueTimeout = time.Second
+
+	// maxClients triggers the cleanup of the idle client rate limiters
+	maxClients = 4096
+	clientIdle = 10 * time.Minute
+)
+
+// Options are options for constructing a Limiter, a zero value disables a limit
+type Options struct {
+	// MaxBodyBytes caps the request body of every path
+	MaxBodyBytes int64
+
+	// PathPrefix selects the paths limited by the rate and concurrency limits
+	PathPrefix string
+
+	// Paths are the metric labels of the limited paths, the other paths are
+	// labeled other so unknown paths cannot grow the series
+	Paths []string
+
+	// MaxInFlight caps the concurrent requests, the others wait up to
+	// QueueTimeout for a free slot before they are rejected with 429
+	MaxInFlight  int
+	QueueTimeout time.Duration
+
+	// ClientQPS and ClientBurst are the token bucket of every client, a client is
+	// identified by the common name of its certificate or by its address
+	ClientQPS   float32
+	ClientBurst int
+
+	// FailOpen answers the shed requests of a path instead of 429, so shedding
+	// the load never blocks scheduling entirely
+	FailOpen map[string]gin.HandlerFunc
+
+	MetricsSubsystem string
+}
+
+// Limiter protects the router against a flood of requests
+type Limiter struct {
+	opt    Options
+	slots  chan struct{}
+	labels map[string]bool
+
+	lock    sync.Mutex
+	clients map[string]*client
+}
+
+type client struct {
+	limiter  flowcontrol.RateLimiter
+	lastSeen time.Time
+}
+
+// NewLimiter returns a Limiter and registers its metrics
+func NewLimiter(opt *Options) *Limiter {
+	l := &Limiter{
+		opt:     *opt,
+		labels:  make(map[string]bool, len(opt.Paths)),
+		clients: make(map[string]*client),
+	}
+	for _, path := range opt.Paths {
+		l.labels[path] = true
+	}
+	if l.opt.QueueTimeout <= 0 {
+		l.opt.QueueTimeout = DefaultQueueTimeout
+	}
+	if l.opt.MaxInFlight > 0 {
+		l.slots = make(chan struct{}, l.opt.MaxInFlight)
+	}
+	registerMetrics(l.opt.MetricsSubsystem)
+	return l
+}
+
+// Middleware returns a gin middleware enforcing the limits, in the order body
+// size, client rate and concurrency
+func (l *Limiter) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		path := c.Request.URL.Path
+		limited := l.opt.PathPrefix == "" || strings.HasPrefix(path, l.opt.PathPrefix)
+		label := "other"
+		if limited && l.labels[path] {
+			label = path
+		}
+
+		if l.opt.MaxBodyBytes > 0 {
+			if c.Request.ContentLength > l.opt.MaxBodyBytes {
+				observeRejected(LimiterBodySize, label)
+				c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"error": "request body too large"})
+				return
+			}
+			// the body of a chunked request is cut when it reaches the limit
+			c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, l.opt.MaxBodyBytes)
+		}
+
+		if !limited {
+			return
+		}
+
+		if l.opt.ClientQPS > 0 && !l.clientLimiter(clientOf(c)).TryAccept() {
+			l.shed(c, LimiterRate, label, http.StatusTooManyRequests)
+			return
+		}
+
+		if l.slots == nil {
+			return
+		}
+		if !l.acquire(label) {
+			l.shed(c, LimiterConcurrency, label, http.StatusTooManyRequests)
+			return
+		}
+		defer l.release()
+		c.Next()
+	}
+}
+
+// shed answers a rejected request, with the fail-open handler of the path when it has one
+func (l *Limiter) shed(c *gin.Context, limiter, label string, status int) {
+	if handler, ok := l.opt.FailOpen[c.Request.URL.Path]; ok {
+		klog.V(3).Infof("%s limit reached, %s fails open", limiter, c.Request.URL.Path)
+		observeFailOpen(limiter, label)
+		handler(c)
+		c.Abort()
+		return
+	}
+
+	observeRejected(limiter, label)
+	c.Header("Retry-After", "1")
+	c.AbortWithStatusJSON(status, gin.H{"error": limiter + " limit reached"})
+}
+
+// acquire waits up to the queue timeout for a free slot
+func (l *Limiter) acquire(label string) bool {
+	select {
+	case l.slots <- struct{}{}:
+		observeInFlight(1)
+		return true
+	default:
+	}
+
+	start := time.Now()
+	timer := time.NewTimer(l.opt.QueueTimeout)
+	defer timer.Stop()
+
+	select {
+	case l.slots <- struct{}{}:
+		observeQueueWait(label, time.Since(start))
+		observeInFlight(1)
+		return true
+	case <-timer.C:
+		observeQueueWait(label, time.Since(start))
+		return false
+	}
+}
+
+func (l *Limiter) release() {
+	<-l.slots
+	observeInFlight(-1)
+}
+
+func (l *Limiter) clientLimiter(key string) flowcontrol.RateLimiter {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	now := time.Now()
+	if cl, ok := l.clients[key]; ok {
+		cl.lastSeen = now
+		return cl.limiter
+	}
+
+	if len(l.clients) >= maxClients {
+		for k, cl := range l.clients {
+			if now.Sub(cl.lastSeen) > clientIdle {
+				delete(l.clients, k)
+			}
+		}
+	}
+
+	burst := l.opt.ClientBurst
+	if burst <= 0 {
+		burst = 1
+	}
+	cl := &client{
+		limiter:  flowcontrol.NewTokenBucketRateLimiter(l.opt.ClientQPS, burst),
+		lastSeen: now,
+	}
+	l.clients[key] = cl
+	return cl.limiter
+}
+
+// clientOf identifies the client by the common name of its verified
+// certificate, or by its address
+func clientOf(c *gin.Context) string {
+	if tls := c.Request.TLS; tls != nil && len(tls.VerifiedChains) > 0 && len(tls.VerifiedChains[0]) > 0 {
+		return "cn:" + tls.VerifiedChains[0][0].Subject.CommonName
+	}
+	return "ip:" + c.ClientIP()
+}
+
+var rejected = &ginprom.Metric{
+	ID:          "rejected",
+	Name:        "limits_rejected_total",
+	Description: "How many requests the limits rejected, partitioned by limiter and path.",
+	Type:        "counter_vec",
+	Args:        []string{"limiter", "path"}}
+
+var failOpen = &ginprom.Metric{
+	ID:          "failOpen",
+	Name:        "limits_fail_open_total",
+	Description: "How many shed requests failed open, partitioned by limiter and path.",
+	Type:        "counter_vec",
+	Args:        []string{"limiter", "path"}}
+
+var inFlight = &ginprom.Metric{
+	ID:          "inFlight",
+	Name:        "limits_in_flight_requests",
+	Description: "The requests holding a concurrency slot.",
+	Type:        "gauge"}
+
+var queueWait = &ginprom.Metric{
+	ID:          "queueWait",
+	Name:        "limits_queue_wait_seconds",
+	Description: "How long the requests waited for a concurrency slot in seconds.",
+	Type:        "histogram_vec",
+	Args:        []string{"path"},
+	Buckets:     prometheus.ExponentialBuckets(0.001, 2, 12)}
+
+var limitsMetrics = []*ginprom.Metric{
+	rejected,
+	failOpen,
+	inFlight,
+	queueWait,
+}
+
+var registerOnce sync.Once
+
+func registerMetrics(subsystem string) {
+	registerOnce.Do(func() {
+		for _, metricDef := range limitsMetrics {
+			metric := ginprom.NewMetric(metricDef, subsystem)
+			if err := prometheus.Register(metric); err != nil {
+				klog.Infof("%s could not be registered: %v", metricDef.Name, err)
+				continue
+			}
+			metricDef.MetricCollector = metric
+		}
+	})
+}
+
+func observeRejected(limiter, path string) {
+	if c, ok := rejected.MetricCollector.(*prometheus.CounterVec); ok {
+		c.WithLabelValues(limiter, path).Inc()
+	}
+}
+
+func observeFailOpen(limiter, path string) {
+	if c, ok := failOpen.MetricCollector.(*prometheus.CounterVec); ok {
+		c.WithLabelValues(limiter, path).Inc()
+	}
+}
+
+func observeInFlight(delta float64) {
+	if g, ok := inFlight.MetricCollector.(prometheus.Gauge); ok {
+		g.Add(delta)
+	}
+}
+
+func observeQueueWait(path string, wait time.Duration) {
+	if h, ok := queueWait.MetricCollector.(*prometheus.HistogramVec); ok {
+		h.WithLabelValues(path).Observe(wait.Seconds())
+	}
+}
@@ -0,0 +1,21 @@
+package logging
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/go-logr/logr"
+	"github.com/xkcp0324/custom-scheduler/pkg/observability/logging"
+)
+
+// Middleware returns a gin middleware putting a logger carrying the request ID
+// into the request context. It must run after the middleware setting the
+// request ID.
+func Middleware(base logr.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		logger := base
+		if reqID, ok := c.Get("requestid"); ok {
+			logger = logger.WithValues("requestID", reqID)
+		}
+		c.Request = c.Request.WithContext(logging.IntoContext(c.Request.Context(), logger))
+		c.Next()
+	}
+}
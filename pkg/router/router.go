@@ -1,16 +1,25 @@
 package router
 
 import (
+	"net"
 	"net/http"
 
 	"context"
-	"crypto/tls"
+	"fmt"
+	"strings"
+	"sync/atomic"
 	"github.com/DeanThompson/ginpprof"
 	"github.com/gin-gonic/gin"
+	"github.com/go-logr/logr"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
 
 	"k8s.io/klog"
 	"time"
 	"github.com/xkcp0324/custom-scheduler/pkg/router/ginprom"
+	"github.com/xkcp0324/custom-scheduler/pkg/router/limits"
+	"github.com/xkcp0324/custom-scheduler/pkg/observability/tracing"
+	"github.com/xkcp0324/custom-scheduler/pkg/router/logging"
+	tracingmw "github.com/xkcp0324/custom-scheduler/pkg/router/tracing"
 	"github.com/xkcp0324/custom-scheduler/pkg/version"
 )
 
@@ -32,21 +41,88 @@ type RouterOptions struct {
 	MetricsSubsystem string
 	MetricsPath      string
 
-	// 	Username      string
-	// 	Password      string
+	// Logger is the base of the request loggers, which carry the request ID
+	Logger logr.Logger
+
+	// Tracer traces every request when it is set
+	Tracer *tracing.Tracer
+
+	// Auth guards the ProtectedPaths of the listeners, see auth.Authorizer. The
+	// ProtectedPaths are served without authentication when it is nil.
+	Auth gin.HandlerFunc
+
+	// Limits protects the Limited listeners against a flood of requests when it is set
+	Limits *limits.Options
+
 	CertFilePath string
 	KeyFilePath  string
+
+	// Listeners split the routes over several addresses. When it is empty a
+	// single listener on Addr serves every route, the metrics when IsMetricsEnabled
+	// and pprof when IsPprofEnabled.
+	Listeners []ListenerOptions
+}
+
+// ListenerOptions are options for a listener of the Router
+type ListenerOptions struct {
+	// Name identifies the listener in the logs
+	Name string
+	Addr string
+
+	// The listener serves https when both files are set, they are reloaded when
+	// they change
+	CertFilePath string
+	KeyFilePath  string
+
+	// SelfSigned generates a self-signed certificate for dev clusters. It is
+	// written to the cert and key files when they are set and missing.
+	SelfSigned bool
+
+	// ClientCAFile enables mutual TLS, the clients must present a certificate
+	// signed by one of its CAs. It is reloaded when it changes.
+	ClientCAFile string
+
+	// AllowedSubjects restricts the clients to the certificates whose common name
+	// or one of the SANs is listed, every verified client is allowed when empty
+	AllowedSubjects []string
+
+	// Groups are the api groups whose routes the listener serves, the groups no
+	// listener claims go to the first listener
+	Groups []string
+
+	// ProtectedPaths are the path prefixes which require the RouterOptions.Auth
+	ProtectedPaths []string
+
+	// ServeMetrics serves the metrics on MetricsPath, it requires IsMetricsEnabled
+	ServeMetrics bool
+
+	// ServePprof serves net/http/pprof under PprofPath
+	ServePprof bool
+
+	// Limited applies the RouterOptions.Limits to the listener
+	Limited bool
 }
 
 // Router handles all incoming HTTP requests
 type Router struct {
+	// Engine is the engine of the first listener
 	*gin.Engine
 	Routes          map[string][]*Route
 	Addr            string
-	httpServer      *http.Server
 	CertFilePath    string
 	KeyFilePath     string
 	ShutdownTimeout time.Duration
+
+	listeners []*listener
+}
+
+type listener struct {
+	ListenerOptions
+	engine     *gin.Engine
+	httpServer *http.Server
+
+	// serving is 1 from the bind of the address until the server stops
+	serving int32
 }
 
 // Route represents an application route
@@ -59,128 +135,267 @@ type Route struct {
 
 // NewRouter creates a new Router instance
 func NewRouter(opt *RouterOptions) *Router {
-	engine := gin.New()
-	engine.Use(gin.Recovery())
-	// engine := gin.Default()
-	// engine.Use(limits.RequestSizeLimiter(int64(options.MaxUploadSize)))
 	if !opt.IsGinLogEnabled {
 		gin.SetMode(gin.ReleaseMode)
-	} else {
-		engine.Use(gin.Logger())
-		// engine.Use(ginlog.Middleware())
 	}
 
-	r := &Router{
-		Engine: engine,
-		Routes: make(map[string][]*Route, 0),
+	listenerOpts := opt.Listeners
+	if len(listenerOpts) == 0 {
+		listenerOpts = []ListenerOptions{{
+			Name:         "default",
+			Addr:         opt.Addr,
+			CertFilePath: opt.CertFilePath,
+			KeyFilePath:  opt.KeyFilePath,
+			ServeMetrics: true,
+			ServePprof:   opt.IsPprofEnabled,
+			Limited:      true,
+		}}
 	}
 
+	logger := opt.Logger
+	if logger == nil {
+		logger = logf.Log.WithName("router")
+	}
+
+	var p *ginprom.Prometheus
 	if opt.IsMetricsEnabled {
-		klog.Infof("start load router path:%s ", opt.MetricsPath)
-		p := ginprom.NewPrometheus(opt.MetricsSubsystem, []string{})
-		p.Use(r.Engine, opt.MetricsPath)
+		p = ginprom.NewPrometheus(opt.MetricsSubsystem, []string{})
 	}
 
-	if opt.IsPprofEnabled {
-		// automatically add routers for net/http/pprof e.g. /debug/pprof, /debug/pprof/heap, etc.
-		ginpprof.Wrap(r.Engine)
+	var limiter *limits.Limiter
+	if opt.Limits != nil {
+		limiter = limits.NewLimiter(opt.Limits)
 	}
 
-	r.CertFilePath = opt.CertFilePath
-	r.KeyFilePath = opt.KeyFilePath
-	r.Addr = opt.Addr
-	r.NoRoute(r.masterHandler)
+	r := &Router{
+		Routes: make(map[string][]*Route, 0),
+	}
+	for _, lo := range listenerOpts {
+		engine := gin.New()
+		engine.Use(gin.Recovery())
+		if opt.IsGinLogEnabled {
+			engine.Use(gin.Logger())
+		}
+		engine.Use(setupContext, logging.Middleware(logger.WithValues("listener", lo.Name)))
+
+		if opt.Tracer != nil {
+			engine.Use(tracingmw.Middleware(opt.Tracer, engine.Routes))
+		}
+
+		if limiter != nil && lo.Limited {
+			engine.Use(limiter.Middleware())
+		}
+
+		// the routes only get the middlewares used before they are added
+		if opt.Auth != nil && len(lo.ProtectedPaths) > 0 {
+			engine.Use(protect(lo.ProtectedPaths, opt.Auth))
+		} else if len(lo.ProtectedPaths) > 0 {
+			klog.Warningf("auth is disabled, paths %v of listener %s are served without authentication", lo.ProtectedPaths, lo.Name)
+		}
+
+		if p != nil {
+			p.UseMiddleware(engine, opt.MetricsPath)
+			if lo.ServeMetrics {
+				klog.Infof("start load router path:%s on listener %s", opt.MetricsPath, lo.Name)
+				p.UseMetricsPath(engine, opt.MetricsPath)
+			}
+		}
+
+		if lo.ServePprof {
+			// automatically add routers for net/http/pprof e.g. /debug/pprof, /debug/pprof/heap, etc.
+			ginpprof.Wrap(engine)
+		}
+
+		engine.NoRoute(r.masterHandler)
+		r.listeners = append(r.listeners, &listener{ListenerOptions: lo, engine: engine})
+	}
+
+	first := r.listeners[0]
+	r.Engine = first.engine
+	r.Addr = first.Addr
+	r.CertFilePath = first.CertFilePath
+	r.KeyFilePath = first.KeyFilePath
 	return r
 }
 
+// Start serves every listener until stopCh is closed or one of them fails
 func (r *Router) Start(stopCh <-chan struct{}) error {
 	if r.ShutdownTimeout == 0 {
 		r.ShutdownTimeout = 5 * time.Second
 	}
 
-	r.httpServer = &http.Server{
-		Addr:         r.Addr,
-		Handler:      r.Engine,
+	errCh := make(chan error, len(r.listeners))
+	for _, l := range r.listeners {
+		if err := l.start(stopCh, errCh); err != nil {
+			r.shutdown()
+			return err
+		}
+	}
+
+	var err error
+	select {
+	case <-stopCh:
+		err = r.shutdown()
+	case err = <-errCh:
+		r.shutdown()
+	}
+
+	if err != nil {
+		klog.Fatalf("Server stop err: %#v", err)
+	} else {
+		klog.Infof("Server exiting")
+	}
+
+	return err
+}
+
+func (l *listener) start(stopCh <-chan struct{}, errCh chan<- error) error {
+	source, err := newTLSSource(&l.ListenerOptions)
+	if err != nil {
+		klog.Errorf("load tls of listener %s err:%+v", l.Name, err)
+		return err
+	}
+
+	l.httpServer = &http.Server{
+		Addr:         l.Addr,
+		Handler:      l.engine,
 		ReadTimeout:  15 * time.Second,
 		WriteTimeout: 15 * time.Second,
 	}
 
-	if r.CertFilePath != "" && r.KeyFilePath != "" {
-		cert, err := tls.LoadX509KeyPair(r.CertFilePath, r.KeyFilePath)
-		if err != nil {
-			klog.Errorf("LoadX509KeyPair err:%+v", err)
-			return err
-		}
-		r.httpServer.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	isTLS := source != nil
+	if isTLS {
+		l.httpServer.TLSConfig = source.config()
+		source.start(stopCh)
 	}
 
-	errCh := make(chan error)
+	// the address is bound before Start returns, so a port in use fails the start
+	ln, err := net.Listen("tcp", l.Addr)
+	if err != nil {
+		klog.Errorf("listener %s listen on %s err:%+v", l.Name, l.Addr, err)
+		return err
+	}
+	atomic.StoreInt32(&l.serving, 1)
+
 	go func() {
-		if r.CertFilePath != "" && r.KeyFilePath != "" {
-			klog.Infof("Listening on https://%s\n", r.Addr)
-			if err := r.httpServer.ListenAndServeTLS(r.CertFilePath, r.KeyFilePath); err != nil && err != http.ErrServerClosed {
-				klog.Error("Https server error: ", err)
+		defer atomic.StoreInt32(&l.serving, 0)
+		if isTLS {
+			klog.Infof("Listener %s listening on https://%s", l.Name, l.Addr)
+			if err := l.httpServer.ServeTLS(ln, "", ""); err != nil && err != http.ErrServerClosed {
+				klog.Errorf("Https server %s error: %v", l.Name, err)
 				errCh <- err
 			}
 		} else {
-			klog.Infof("Listening on http://%s\n", r.Addr)
-			if err := r.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-				klog.Error("Http server error: ", err)
+			klog.Infof("Listener %s listening on http://%s", l.Name, l.Addr)
+			if err := l.httpServer.Serve(ln); err != nil && err != http.ErrServerClosed {
+				klog.Errorf("Http server %s error: %v", l.Name, err)
 				errCh <- err
 			}
 		}
 	}()
+	return nil
+}
 
-	var err error
-	select {
-	case <-stopCh:
-		klog.Info("Shutting down the http/https:%s server...", r.Addr)
+// shutdown stops the started listeners and returns the first error
+func (r *Router) shutdown() error {
+	var ret error
+	for _, l := range r.listeners {
+		if l.httpServer == nil {
+			continue
+		}
+		klog.Infof("Shutting down the listener %s on %s...", l.Name, l.Addr)
+		// the replica turns unready while its requests drain
+		atomic.StoreInt32(&l.serving, 0)
+		var err error
 		if r.ShutdownTimeout > 0 {
 			ctx, cancel := context.WithTimeout(context.Background(), r.ShutdownTimeout)
-			defer cancel()
-			err = r.httpServer.Shutdown(ctx)
+			err = l.httpServer.Shutdown(ctx)
+			cancel()
 		} else {
-			err = r.httpServer.Close()
+			err = l.httpServer.Close()
+		}
+		if err != nil && ret == nil {
+			ret = err
 		}
-	case err = <-errCh:
 	}
+	return ret
+}
 
-	if err != nil {
-		klog.Fatalf("Server stop err: %#v", err)
-	} else {
-		klog.Infof("Server exiting")
-	}
+// NeedLeaderElection implements manager.LeaderElectionRunnable. The extender
+// is stateless, so every replica serves it and not only the leader.
+func (r *Router) NeedLeaderElection() bool {
+	return false
+}
 
-	return err
+// Serving returns an error naming the listeners which are not serving, it is
+// meant for a readiness check
+func (r *Router) Serving() error {
+	stopped := []string{}
+	for _, l := range r.listeners {
+		if atomic.LoadInt32(&l.serving) == 0 {
+			stopped = append(stopped, l.Name)
+		}
+	}
+	if len(stopped) > 0 {
+		return fmt.Errorf("listeners not serving: %s", strings.Join(stopped, ", "))
+	}
+	return nil
 }
 
 func (r *Router) StartWarp(stopCh <-chan struct{}) {
 	_ = r.Start(stopCh)
 }
 
-// SetRoutes applies list of routes
+// SetRoutes applies list of routes to the listeners serving the api group
 func (r *Router) AddRoutes(apiGroup string, routes []*Route) {
 	klog.V(3).Infof("load apiGroup:%s", apiGroup)
-	for _, route := range routes {
-		switch route.Method {
-		case "GET":
-			r.GET(route.Path, route.Handler)
-		case "POST":
-			r.POST(route.Path, route.Handler)
-		case "DELETE":
-			r.DELETE(route.Path, route.Handler)
-		case "Any":
-			r.Any(route.Path, route.Handler)
-		default:
-			klog.Warningf("no method:%s apiGroup:%s", route.Method, apiGroup)
+	for _, l := range r.listenersOf(apiGroup) {
+		for _, route := range routes {
+			switch route.Method {
+			case "GET":
+				l.engine.GET(route.Path, route.Handler)
+			case "POST":
+				l.engine.POST(route.Path, route.Handler)
+			case "DELETE":
+				l.engine.DELETE(route.Path, route.Handler)
+			case "Any":
+				l.engine.Any(route.Path, route.Handler)
+			default:
+				klog.Warningf("no method:%s apiGroup:%s", route.Method, apiGroup)
+			}
 		}
 	}
 
-	if rs, ok := r.Routes[apiGroup]; !ok {
-		r.Routes[apiGroup] = routes
-	} else {
-		rs = append(rs, routes...)
+	r.Routes[apiGroup] = append(r.Routes[apiGroup], routes...)
+}
+
+// protect runs auth on the requests whose path starts with one of the prefixes
+func protect(prefixes []string, auth gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(c.Request.URL.Path, prefix) {
+				auth(c)
+				return
+			}
+		}
+	}
+}
+
+// listenersOf returns the listeners claiming the api group, or the first one
+func (r *Router) listenersOf(apiGroup string) []*listener {
+	var ret []*listener
+	for _, l := range r.listeners {
+		for _, group := range l.Groups {
+			if group == apiGroup {
+				ret = append(ret, l)
+				break
+			}
+		}
+	}
+	if len(ret) == 0 {
+		ret = append(ret, r.listeners[0])
 	}
+	return ret
 }
 
 // all incoming requests are passed through this handler
@@ -0,0 +1,67 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestProtectedPaths(t *testing.T) {
+	deny := func(c *gin.Context) {
+		c.AbortWithStatus(http.StatusUnauthorized)
+	}
+
+	for _, tc := range []struct {
+		name string
+		auth gin.HandlerFunc
+		want map[string]int
+	}{
+		{
+			name: "auth disabled",
+			want: map[string]int{
+				MetricsPath:          http.StatusOK,
+				"/scheduler/explain": http.StatusOK,
+				"/open":              http.StatusOK,
+			},
+		},
+		{
+			name: "auth enabled",
+			auth: deny,
+			want: map[string]int{
+				MetricsPath:          http.StatusUnauthorized,
+				"/scheduler/explain": http.StatusUnauthorized,
+				"/open":              http.StatusOK,
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := NewRouter(&RouterOptions{
+				IsMetricsEnabled: true,
+				MetricsPath:      MetricsPath,
+				MetricsSubsystem: "router_test",
+				Auth:             tc.auth,
+				Listeners: []ListenerOptions{{
+					Name:           "admin",
+					Groups:         []string{"inspect"},
+					ProtectedPaths: []string{MetricsPath, "/scheduler/explain"},
+					ServeMetrics:   true,
+				}},
+			})
+			ok := func(c *gin.Context) { c.String(http.StatusOK, "ok") }
+			r.AddRoutes("inspect", []*Route{
+				{Method: "GET", Path: "/scheduler/explain", Handler: ok},
+				{Method: "GET", Path: "/open", Handler: ok},
+			})
+
+			for path, want := range tc.want {
+				w := httptest.NewRecorder()
+				r.Engine.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+				if w.Code != want {
+					t.Errorf("GET %s = %d, want %d", path, w.Code, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,260 @@
+package router
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/xkcp0324/custom-scheduler/pkg/config"
+	"k8s.io/klog"
+)
+
+const (
+	// selfSignedValidity is how long a self-signed certificate is valid
+	selfSignedValidity = 365 * 24 * time.Hour
+)
+
+// tlsSource holds the certificate and the client CAs of a listener, they are
+// reloaded when their files change so the certificates rotate without a restart
+type tlsSource struct {
+	lock      sync.RWMutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+
+	certFile string
+	keyFile  string
+
+	// allowedSubjects are matched against the common name and the SANs of the
+	// client certificate, any verified client is allowed when it is empty
+	allowedSubjects map[string]bool
+
+	reloaders []*config.Reloader
+}
+
+// newTLSSource returns the tlsSource of the listener, nil when it serves plain http
+func newTLSSource(lo *ListenerOptions) (*tlsSource, error) {
+	hasFiles := lo.CertFilePath != "" && lo.KeyFilePath != ""
+	if !hasFiles && !lo.SelfSigned {
+		if lo.ClientCAFile != "" {
+			return nil, fmt.Errorf("listener %s: a client CA requires a certificate", lo.Name)
+		}
+		return nil, nil
+	}
+
+	s := &tlsSource{
+		certFile: lo.CertFilePath,
+		keyFile:  lo.KeyFilePath,
+	}
+	if len(lo.AllowedSubjects) > 0 {
+		s.allowedSubjects = make(map[string]bool, len(lo.AllowedSubjects))
+		for _, subject := range lo.AllowedSubjects {
+			s.allowedSubjects[subject] = true
+		}
+	}
+
+	if lo.SelfSigned {
+		if err := s.bootstrap(lo.Addr); err != nil {
+			return nil, fmt.Errorf("listener %s: %v", lo.Name, err)
+		}
+	}
+
+	if s.certFile != "" {
+		// either file may change first, each reloader loads the pair
+		s.reloaders = append(s.reloaders,
+			config.NewReloader(s.certFile, config.DefaultReloadInterval, s.loadKeyPair),
+			config.NewReloader(s.keyFile, config.DefaultReloadInterval, s.loadKeyPair))
+	}
+	if lo.ClientCAFile != "" {
+		s.reloaders = append(s.reloaders,
+			config.NewReloader(lo.ClientCAFile, config.DefaultReloadInterval, s.loadClientCAs))
+	}
+
+	for _, r := range s.reloaders {
+		if err := r.Load(); err != nil {
+			return nil, fmt.Errorf("listener %s: %v", lo.Name, err)
+		}
+	}
+	if lo.ClientCAFile == "" && len(s.allowedSubjects) > 0 {
+		return nil, fmt.Errorf("listener %s: allowed subjects require a client CA", lo.Name)
+	}
+	return s, nil
+}
+
+func (s *tlsSource) loadKeyPair([]byte) error {
+	cert, err := tls.LoadX509KeyPair(s.certFile, s.keyFile)
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.cert = &cert
+	return nil
+}
+
+func (s *tlsSource) loadClientCAs(data []byte) error {
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return fmt.Errorf("no certificate found")
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.clientCAs = pool
+	return nil
+}
+
+// bootstrap generates a self-signed certificate. It is written to the cert and
+// key files when they are set and do not exist yet, it is kept in memory otherwise.
+func (s *tlsSource) bootstrap(addr string) error {
+	if s.certFile != "" && fileExists(s.certFile) && fileExists(s.keyFile) {
+		return nil
+	}
+
+	certPEM, keyPEM, err := selfSignedCert(addr)
+	if err != nil {
+		return err
+	}
+
+	if s.certFile == "" {
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			return err
+		}
+		s.cert = &cert
+		klog.Warningf("serving %s with an in-memory self-signed certificate", addr)
+		return nil
+	}
+
+	if err := ioutil.WriteFile(s.certFile, certPEM, 0644); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(s.keyFile, keyPEM, 0600); err != nil {
+		return err
+	}
+	klog.Warningf("generated a self-signed certificate %s for %s", s.certFile, addr)
+	return nil
+}
+
+// selfSignedCert returns a PEM certificate and key valid for localhost, the
+// host name and the host of addr
+func selfSignedCert(addr string) ([]byte, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hostname, _ := os.Hostname()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "custom-scheduler"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("::1")},
+	}
+	if hostname != "" {
+		template.DNSNames = append(template.DNSNames, hostname)
+	}
+	if host, _, err := net.SplitHostPort(addr); err == nil && host != "" {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM, nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// config returns the tls.Config of the listener, every handshake reads the
+// current certificate and client CAs
+func (s *tlsSource) config() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			s.lock.RLock()
+			defer s.lock.RUnlock()
+
+			cfg := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*s.cert},
+			}
+			if s.clientCAs != nil {
+				cfg.ClientAuth = tls.RequireAndVerifyClientCert
+				cfg.ClientCAs = s.clientCAs
+				cfg.VerifyPeerCertificate = s.verifySubject
+			}
+			return cfg, nil
+		},
+	}
+}
+
+// verifySubject checks the verified client certificate against the allowed subjects
+func (s *tlsSource) verifySubject(_ [][]byte, verifiedChains [][]*x509.Certificate) error {
+	if len(s.allowedSubjects) == 0 {
+		return nil
+	}
+	if len(verifiedChains) == 0 || len(verifiedChains[0]) == 0 {
+		return fmt.Errorf("no verified client certificate")
+	}
+
+	leaf := verifiedChains[0][0]
+	subjects := []string{leaf.Subject.CommonName}
+	subjects = append(subjects, leaf.DNSNames...)
+	subjects = append(subjects, leaf.EmailAddresses...)
+	for _, uri := range leaf.URIs {
+		subjects = append(subjects, uri.String())
+	}
+	for _, subject := range subjects {
+		if s.allowedSubjects[subject] {
+			return nil
+		}
+	}
+	return fmt.Errorf("client %q is not allowed", leaf.Subject.CommonName)
+}
+
+// start polls the certificate files until stopCh is closed
+func (s *tlsSource) start(stopCh <-chan struct{}) {
+	for _, r := range s.reloaders {
+		go func(r *config.Reloader) {
+			_ = r.Start(stopCh)
+		}(r)
+	}
+}
@@ -0,0 +1,94 @@
+package tracing
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xkcp0324/custom-scheduler/pkg/observability/tracing"
+)
+
+// unmatchedRoute names the spans of the requests which match no route
+const unmatchedRoute = "unmatched"
+
+// Middleware returns a gin middleware starting a span for every request. The
+// span continues the trace of an incoming traceparent header and is available
+// to the handlers through tracing.SpanFromContext(c.Request.Context()). It is
+// named after the route template, the routes are read when the request ends
+// as they are added after the middleware.
+func Middleware(tracer *tracing.Tracer, routes func() gin.RoutesInfo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := tracing.Extract(c.Request.Context(), c.Request.Header)
+		ctx, span := tracer.StartRootSpan(ctx, c.Request.Method)
+		span.SetAttribute("http.method", c.Request.Method)
+		span.SetAttribute("http.target", c.Request.URL.Path)
+		if reqID, ok := c.Get("requestid"); ok {
+			span.SetAttribute("http.request_id", reqID.(string))
+		}
+		c.Request = c.Request.WithContext(ctx)
+		tracing.Inject(ctx, c.Writer.Header())
+
+		c.Next()
+
+		route := routeOf(routes(), c.Request.Method, c.Request.URL.Path)
+		span.SetName(c.Request.Method + " " + route)
+		span.SetAttribute("http.route", route)
+		status := c.Writer.Status()
+		span.SetAttribute("http.status_code", strconv.Itoa(status))
+		if len(c.Errors) > 0 {
+			span.SetError(c.Errors.Last())
+		} else if status >= 500 {
+			span.SetError(errStatus(status))
+		}
+		span.Finish()
+	}
+}
+
+// routeOf returns the template of the route serving the path, a static route
+// wins over a route with parameters like in gin
+func routeOf(routes gin.RoutesInfo, method, path string) string {
+	ret := unmatchedRoute
+	for _, route := range routes {
+		if route.Method != method {
+			continue
+		}
+		if route.Path == path {
+			return route.Path
+		}
+		if ret == unmatchedRoute && matchTemplate(route.Path, path) {
+			ret = route.Path
+		}
+	}
+	return ret
+}
+
+// matchTemplate returns whether the path matches a template with :param and
+// *catchAll segments
+func matchTemplate(template, path string) bool {
+	tparts := strings.Split(template, "/")
+	parts := strings.Split(path, "/")
+	for i, tpart := range tparts {
+		if strings.HasPrefix(tpart, "*") {
+			return true
+		}
+		if i >= len(parts) {
+			return false
+		}
+		if strings.HasPrefix(tpart, ":") {
+			if parts[i] == "" {
+				return false
+			}
+			continue
+		}
+		if tpart != parts[i] {
+			return false
+		}
+	}
+	return len(tparts) == len(parts)
+}
+
+type errStatus int
+
+func (e errStatus) Error() string {
+	return "http status " + strconv.Itoa(int(e))
+}
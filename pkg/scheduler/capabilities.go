@@ -0,0 +1,41 @@
+package scheduler
+
+// The verbs of the extender, the routes are served under URLPath
+const (
+	URLPath = "/scheduler"
+
+	FilterVerb     = "filter"
+	PrioritizeVerb = "prioritize"
+	BindVerb       = "bind"
+)
+
+// Capabilities describe what the extender serves, they are the source of the
+// kube-scheduler Policy and KubeSchedulerConfiguration rendered by render-config
+type Capabilities struct {
+	FilterVerb     string
+	PrioritizeVerb string
+
+	// BindVerb is only set when the extender binds the pods, kube-scheduler
+	// binds them otherwise
+	BindVerb string
+
+	// NodeCacheCapable is false, the predicates read the labels and the
+	// annotations of the nodes in the args
+	NodeCacheCapable bool
+
+	// ManagedResources are the extended resources the extender accounts for
+	ManagedResources []string
+}
+
+// ExtenderCapabilities returns the capabilities of the extender, bind tells
+// whether kube-scheduler should call the bind verb
+func ExtenderCapabilities(bind bool) Capabilities {
+	c := Capabilities{
+		FilterVerb:     FilterVerb,
+		PrioritizeVerb: PrioritizeVerb,
+	}
+	if bind {
+		c.BindVerb = BindVerb
+	}
+	return c
+}
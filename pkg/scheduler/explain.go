@@ -0,0 +1,158 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/xkcp0324/custom-scheduler/pkg/scheduler/predicates"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ExplainRequest selects the pod to explain, either a pod spec or the namespace
+// and name of a pod in the cache
+type ExplainRequest struct {
+	Pod       *corev1.Pod `json:"pod,omitempty"`
+	Namespace string      `json:"namespace,omitempty"`
+	Name      string      `json:"name,omitempty"`
+}
+
+// Explanation traces the decisions of the predicates for a pod
+type Explanation struct {
+	Pod     string `json:"pod"`
+	Profile string `json:"profile"`
+
+	// Nodes is sorted by rank, the rejected nodes last
+	Nodes []NodeTrace `json:"nodes"`
+
+	// Ranking lists the feasible nodes from the best to the worst
+	Ranking []string `json:"ranking"`
+
+	Scores []PredicateScores `json:"scores,omitempty"`
+
+	// FailOpen is predicate => error of the predicates skipped in fail-open mode
+	FailOpen map[string]string `json:"failOpen,omitempty"`
+	Error    string            `json:"error,omitempty"`
+}
+
+// NodeTrace is the decision on a single node
+type NodeTrace struct {
+	Node      string     `json:"node"`
+	Feasible  bool       `json:"feasible"`
+	Rejection *Rejection `json:"rejection,omitempty"`
+
+	// Scores is predicate => normalized and weighted score
+	Scores map[string]int `json:"scores,omitempty"`
+	Total  int            `json:"total"`
+	Rank   int            `json:"rank,omitempty"`
+}
+
+// Explain runs every predicate of the pod profile on the nodes in the cache
+func (s *scheduler) Explain(ctx context.Context, req *ExplainRequest) (*Explanation, error) {
+	pod, err := s.explainPod(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	nodeList := &corev1.NodeList{}
+	if err := s.mgr.GetClient().List(ctx, nodeList); err != nil {
+		return nil, fmt.Errorf("list node err: %v", err)
+	}
+
+	profile, predicatesByProfile, _ := s.profileOf(pod)
+	ctx = predicates.WithExplain(withPod(ctx, pod, profile))
+	explanation := &Explanation{
+		Pod:     fmt.Sprintf("%s/%s", pod.GetNamespace(), pod.GetName()),
+		Profile: profile,
+		Ranking: []string{},
+	}
+
+	var feasible []corev1.Node
+	rejections := map[string]Rejection{}
+	trace, err := s.runFilters(ctx, predicatesByProfile, pod, nodeList.Items)
+	if err != nil {
+		// a filter error fails the whole scheduling attempt
+		explanation.Error = err.Error()
+	} else {
+		feasible = trace.nodes
+		rejections = trace.rejections
+		if len(trace.failOpen) > 0 {
+			explanation.FailOpen = trace.failOpen
+		}
+	}
+
+	result, scores := s.runPriorities(ctx, predicatesByProfile, pod, feasible)
+	explanation.Scores = scores
+
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].Score != result[j].Score {
+			return result[i].Score > result[j].Score
+		}
+		return result[i].Host < result[j].Host
+	})
+
+	for i, hp := range result {
+		trace := NodeTrace{
+			Node:     hp.Host,
+			Feasible: true,
+			Scores:   make(map[string]int),
+			Total:    hp.Score,
+			Rank:     i + 1,
+		}
+		for _, ps := range scores {
+			if score, ok := ps.Normalized[hp.Host]; ok {
+				trace.Scores[ps.Predicate] = score * ps.Weight
+			}
+		}
+		explanation.Nodes = append(explanation.Nodes, trace)
+		explanation.Ranking = append(explanation.Ranking, hp.Host)
+	}
+
+	var rejected []NodeTrace
+	for _, node := range nodeList.Items {
+		r, ok := rejections[node.Name]
+		if !ok && err == nil {
+			continue
+		}
+		trace := NodeTrace{Node: node.Name}
+		if ok {
+			trace.Rejection = &r
+		}
+		rejected = append(rejected, trace)
+	}
+	sort.Slice(rejected, func(i, j int) bool {
+		return rejected[i].Node < rejected[j].Node
+	})
+	explanation.Nodes = append(explanation.Nodes, rejected...)
+
+	return explanation, nil
+}
+
+func (s *scheduler) explainPod(ctx context.Context, req *ExplainRequest) (*corev1.Pod, error) {
+	if req.Pod != nil {
+		pod := req.Pod.DeepCopy()
+		if pod.Namespace == "" {
+			pod.Namespace = corev1.NamespaceDefault
+		}
+		return pod, nil
+	}
+
+	if req.Name == "" {
+		return nil, fmt.Errorf("either pod or name is required")
+	}
+
+	ns := req.Namespace
+	if ns == "" {
+		ns = corev1.NamespaceDefault
+	}
+
+	pod := &corev1.Pod{}
+	if err := s.mgr.GetClient().Get(ctx, client.ObjectKey{Namespace: ns, Name: req.Name}, pod); err != nil {
+		return nil, fmt.Errorf("get pod %s/%s err: %v", ns, req.Name, err)
+	}
+
+	// explain the pod as if it was pending
+	pod.Spec.NodeName = ""
+	return pod, nil
+}
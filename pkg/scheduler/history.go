@@ -0,0 +1,316 @@
+package scheduler
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/xkcp0324/custom-scheduler/pkg/scheduler/metrics"
+	"k8s.io/klog"
+)
+
+const (
+	// DefaultHistorySize is the default number of decisions kept in memory
+	DefaultHistorySize = 1000
+
+	// historyQueueSize is the number of decisions waiting to be written to the
+	// file, the decisions beyond it are only kept in memory
+	historyQueueSize = 1024
+)
+
+// Decision records a filter or prioritize call
+type Decision struct {
+	Time      time.Time `json:"time"`
+	Verb      string    `json:"verb"`
+	Namespace string    `json:"namespace"`
+	Pod       string    `json:"pod"`
+	UID       string    `json:"uid,omitempty"`
+	Profile   string    `json:"profile"`
+
+	// InputNodes is the number of nodes sent by kube-scheduler
+	InputNodes int `json:"inputNodes"`
+
+	// Nodes are the nodes left by the filters
+	Nodes       []string          `json:"nodes,omitempty"`
+	FailedNodes map[string]string `json:"failedNodes,omitempty"`
+
+	// FailOpen is predicate => error of the predicates skipped in fail-open mode
+	FailOpen map[string]string `json:"failOpen,omitempty"`
+
+	// Scores are the scores of every predicate and Totals the final scores
+	Scores []PredicateScores `json:"scores,omitempty"`
+	Totals map[string]int    `json:"totals,omitempty"`
+
+	LatencyMs float64 `json:"latencyMs"`
+	Error     string  `json:"error,omitempty"`
+
+	// Shed is set when the request was shed by the router limits, the
+	// predicates did not run and the latency is not measured
+	Shed bool `json:"shed,omitempty"`
+
+	// seq orders the decisions, the writer skips the ones already compacted
+	seq uint64
+}
+
+// DecisionQuery selects decisions, an empty field matches everything
+type DecisionQuery struct {
+	Namespace string
+	Pod       string
+	Since     time.Time
+}
+
+// History keeps the latest decisions in a ring buffer. When a file is set the
+// decisions are appended to it in the background and loaded back on start.
+type History struct {
+	lock    sync.RWMutex
+	records []Decision
+	next    int
+	full    bool
+	seq     uint64
+
+	path string
+	// pending are the decisions waiting to be appended to the file
+	pending chan Decision
+
+	// the file is only touched by the writer once the history is created
+	file      *os.File
+	written   int
+	compacted uint64
+}
+
+// NewHistory returns a History of the given size, persisted to path unless it is empty
+func NewHistory(size int, path string) (*History, error) {
+	if size <= 0 {
+		size = DefaultHistorySize
+	}
+
+	h := &History{
+		records: make([]Decision, size),
+		path:    path,
+	}
+
+	if path == "" {
+		return h, nil
+	}
+
+	if err := h.load(); err != nil {
+		return nil, err
+	}
+	if err := h.compact(h.snapshot()); err != nil {
+		return nil, err
+	}
+
+	h.pending = make(chan Decision, historyQueueSize)
+	go h.persist()
+	return h, nil
+}
+
+// Add records a decision, the oldest one is dropped when the buffer is full. The
+// decision is written to the file in the background, it is only kept in memory
+// when the writer falls behind.
+func (h *History) Add(d Decision) {
+	h.lock.Lock()
+	d = h.add(d)
+	h.lock.Unlock()
+
+	if h.pending == nil {
+		return
+	}
+	select {
+	case h.pending <- d:
+	default:
+		klog.V(3).Infof("history queue is full, decision of %s/%s is not written to %s", d.Namespace, d.Pod, h.path)
+	}
+}
+
+// persist appends the pending decisions to the file, it keeps at most twice the
+// buffer before it rewrites the file. The file is rewritten on the next decision
+// when it could not be reopened after a compaction.
+func (h *History) persist() {
+	for d := range h.pending {
+		if d.seq <= h.compacted {
+			continue
+		}
+		if h.file == nil {
+			// the buffer holds the decision, the rewrite writes it
+			h.tryCompact()
+			continue
+		}
+
+		data, err := json.Marshal(d)
+		if err != nil {
+			klog.Errorf("marshal decision err: %+v", err)
+			continue
+		}
+		if _, err := h.file.Write(append(data, '\n')); err != nil {
+			klog.Errorf("write decision to %s err: %+v", h.path, err)
+			metrics.ObserveHistoryError("write")
+			continue
+		}
+
+		h.written++
+		if h.written >= 2*len(h.records) {
+			h.tryCompact()
+		}
+	}
+}
+
+// tryCompact compacts the file, a failure is logged and counted
+func (h *History) tryCompact() {
+	if err := h.compact(h.snapshot()); err != nil {
+		klog.Errorf("compact %s err: %+v", h.path, err)
+		metrics.ObserveHistoryError("compact")
+	}
+}
+
+// snapshot returns a copy of the decisions and the seq of the latest one
+func (h *History) snapshot() ([]Decision, uint64) {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	return append([]Decision(nil), h.ordered()...), h.seq
+}
+
+func (h *History) add(d Decision) Decision {
+	h.seq++
+	d.seq = h.seq
+	h.records[h.next] = d
+	h.next = (h.next + 1) % len(h.records)
+	if h.next == 0 {
+		h.full = true
+	}
+	return d
+}
+
+// Query returns the matching decisions from the oldest to the latest
+func (h *History) Query(q *DecisionQuery) []Decision {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	ret := []Decision{}
+	for _, d := range h.ordered() {
+		if q.Namespace != "" && d.Namespace != q.Namespace {
+			continue
+		}
+		if q.Pod != "" && d.Pod != q.Pod {
+			continue
+		}
+		if !q.Since.IsZero() && d.Time.Before(q.Since) {
+			continue
+		}
+		ret = append(ret, d)
+	}
+	return ret
+}
+
+// LatencyQuantile returns the quantile of the latency of the filter and
+// prioritize calls since the given time, with the number of calls. The shed
+// calls are left out, they would make a loaded extender look faster.
+func (h *History) LatencyQuantile(q float64, since time.Time) (time.Duration, int) {
+	h.lock.RLock()
+	latencies := []float64{}
+	for _, d := range h.ordered() {
+		if d.Time.Before(since) || d.Shed {
+			continue
+		}
+		latencies = append(latencies, d.LatencyMs)
+	}
+	h.lock.RUnlock()
+
+	if len(latencies) == 0 {
+		return 0, 0
+	}
+	sort.Float64s(latencies)
+	i := int(math.Ceil(q*float64(len(latencies)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(latencies) {
+		i = len(latencies) - 1
+	}
+	return time.Duration(latencies[i] * float64(time.Millisecond)), len(latencies)
+}
+
+func (h *History) ordered() []Decision {
+	if !h.full {
+		return h.records[:h.next]
+	}
+	ret := make([]Decision, 0, len(h.records))
+	ret = append(ret, h.records[h.next:]...)
+	return append(ret, h.records[:h.next]...)
+}
+
+// load reads the decisions of the file into the buffer, the lines which can not
+// be parsed are skipped
+func (h *History) load() error {
+	f, err := os.Open(h.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		d := Decision{}
+		if err := json.Unmarshal(scanner.Bytes(), &d); err != nil {
+			klog.V(3).Infof("skip decision of %s: %v", h.path, err)
+			continue
+		}
+		h.add(d)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("read %s err: %v", h.path, err)
+	}
+
+	klog.Infof("loaded %d decisions from %s", len(h.ordered()), h.path)
+	return nil
+}
+
+// compact rewrites the file with the decisions of the buffer, seq is the latest
+// of them
+func (h *History) compact(records []Decision, seq uint64) error {
+	tmp := h.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, d := range records {
+		if err := enc.Encode(d); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, h.path); err != nil {
+		return err
+	}
+
+	if h.file != nil {
+		h.file.Close()
+	}
+	h.file, err = os.OpenFile(h.path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		h.file = nil
+		return fmt.Errorf("reopen %s err: %v", h.path, err)
+	}
+	h.written = len(records)
+	h.compacted = seq
+	return nil
+}
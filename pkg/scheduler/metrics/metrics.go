@@ -0,0 +1,158 @@
+package metrics
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/xkcp0324/custom-scheduler/pkg/router/ginprom"
+	"k8s.io/klog"
+)
+
+// The phases of a predicate
+const (
+	PhaseFilter = "filter"
+	PhaseScore  = "score"
+)
+
+var filterDur = &ginprom.Metric{
+	ID:          "filterDur",
+	Name:        "filter_duration_seconds",
+	Description: "The filter latencies of a predicate in seconds.",
+	Type:        "histogram_vec",
+	Args:        []string{"plugin", "profile"},
+	Buckets:     prometheus.ExponentialBuckets(0.0005, 2, 15)}
+
+var scoreDur = &ginprom.Metric{
+	ID:          "scoreDur",
+	Name:        "score_duration_seconds",
+	Description: "The priority latencies of a predicate in seconds.",
+	Type:        "histogram_vec",
+	Args:        []string{"plugin", "profile"},
+	Buckets:     prometheus.ExponentialBuckets(0.0005, 2, 15)}
+
+var filterNodes = &ginprom.Metric{
+	ID:          "filterNodes",
+	Name:        "filter_nodes_total",
+	Description: "How many nodes entered and left the filter of a predicate, partitioned by direction.",
+	Type:        "counter_vec",
+	Args:        []string{"plugin", "profile", "direction"}}
+
+var filterRejections = &ginprom.Metric{
+	ID:          "filterRejections",
+	Name:        "filter_rejections_total",
+	Description: "How many nodes the filter of a predicate rejected, partitioned by reason.",
+	Type:        "counter_vec",
+	Args:        []string{"plugin", "profile", "reason"}}
+
+var scores = &ginprom.Metric{
+	ID:          "scores",
+	Name:        "normalized_score",
+	Description: "The normalized scores given to the nodes by a predicate.",
+	Type:        "histogram_vec",
+	Args:        []string{"plugin", "profile"},
+	Buckets:     prometheus.LinearBuckets(0, 1, 11)}
+
+var pluginErrors = &ginprom.Metric{
+	ID:          "pluginErrors",
+	Name:        "plugin_errors_total",
+	Description: "How many errors a predicate returned, partitioned by phase.",
+	Type:        "counter_vec",
+	Args:        []string{"plugin", "profile", "phase"}}
+
+var historyErrors = &ginprom.Metric{
+	ID:          "historyErrors",
+	Name:        "history_errors_total",
+	Description: "How many times the decision history failed to be written to its file, partitioned by operation.",
+	Type:        "counter_vec",
+	Args:        []string{"op"}}
+
+var schedulerMetrics = []*ginprom.Metric{
+	filterDur,
+	scoreDur,
+	filterNodes,
+	filterRejections,
+	scores,
+	pluginErrors,
+	historyErrors,
+}
+
+var (
+	registerOnce sync.Once
+	subsystem    string
+)
+
+// Register registers the scheduler metrics in the prometheus default registry,
+// the same registry ginprom exposes
+func Register(metricsSubsystem string) {
+	registerOnce.Do(func() {
+		subsystem = metricsSubsystem
+		for _, metricDef := range schedulerMetrics {
+			metric := ginprom.NewMetric(metricDef, subsystem)
+			if err := prometheus.Register(metric); err != nil {
+				klog.Infof("%s could not be registered: %v", metricDef.Name, err)
+				continue
+			}
+			klog.V(3).Infof("%s registered.", metricDef.Name)
+			metricDef.MetricCollector = metric
+		}
+	})
+}
+
+// RegisterCacheSize registers a gauge of the number of objects of a kind in a
+// cache, the size is read on every scrape
+func RegisterCacheSize(kind string, size func() float64) {
+	gauge := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Subsystem:   subsystem,
+		Name:        "cache_objects",
+		Help:        "The number of objects in the scheduler caches, partitioned by kind.",
+		ConstLabels: prometheus.Labels{"kind": kind},
+	}, size)
+	if err := prometheus.Register(gauge); err != nil {
+		klog.Infof("cache_objects of %s could not be registered: %v", kind, err)
+	}
+}
+
+// ObserveFilter records the latency and the nodes in and out of a filter
+func ObserveFilter(plugin, profile string, latency time.Duration, in, out int) {
+	if h, ok := filterDur.MetricCollector.(*prometheus.HistogramVec); ok {
+		h.WithLabelValues(plugin, profile).Observe(latency.Seconds())
+	}
+	if c, ok := filterNodes.MetricCollector.(*prometheus.CounterVec); ok {
+		c.WithLabelValues(plugin, profile, "in").Add(float64(in))
+		c.WithLabelValues(plugin, profile, "out").Add(float64(out))
+	}
+}
+
+// ObserveRejection records a node rejected by a filter
+func ObserveRejection(plugin, profile, reason string) {
+	if c, ok := filterRejections.MetricCollector.(*prometheus.CounterVec); ok {
+		c.WithLabelValues(plugin, profile, reason).Inc()
+	}
+}
+
+// ObserveScore records the latency and the normalized scores of a priority
+func ObserveScore(plugin, profile string, latency time.Duration, normalized map[string]int) {
+	if h, ok := scoreDur.MetricCollector.(*prometheus.HistogramVec); ok {
+		h.WithLabelValues(plugin, profile).Observe(latency.Seconds())
+	}
+	if h, ok := scores.MetricCollector.(*prometheus.HistogramVec); ok {
+		for _, score := range normalized {
+			h.WithLabelValues(plugin, profile).Observe(float64(score))
+		}
+	}
+}
+
+// ObserveError records an error of a predicate in a phase
+func ObserveError(plugin, profile, phase string) {
+	if c, ok := pluginErrors.MetricCollector.(*prometheus.CounterVec); ok {
+		c.WithLabelValues(plugin, profile, phase).Inc()
+	}
+}
+
+// ObserveHistoryError records a failed write or compaction of the history file
+func ObserveHistoryError(op string) {
+	if c, ok := historyErrors.MetricCollector.(*prometheus.CounterVec); ok {
+		c.WithLabelValues(op).Inc()
+	}
+}
@@ -0,0 +1,329 @@
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/xkcp0324/custom-scheduler/pkg/scheduler"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/version"
+	schedulerapiv1 "k8s.io/kubernetes/pkg/scheduler/api/v1"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// DefaultKubernetesVersion is the version the binary is built against
+	DefaultKubernetesVersion = "1.14.6"
+
+	// DefaultURLPrefix is the extender in the pod of kube-scheduler
+	DefaultURLPrefix = "http://127.0.0.1:8080" + scheduler.URLPath
+
+	// DefaultHTTPTimeout is the timeout of kube-scheduler calling the extender
+	DefaultHTTPTimeout = 30 * time.Second
+)
+
+// Options are the deployment of the extender, its capabilities come from the
+// scheduler package
+type Options struct {
+	// KubernetesVersion is the version of kube-scheduler
+	KubernetesVersion string
+
+	URLPrefix   string
+	Weight      int
+	HTTPTimeout time.Duration
+
+	// Bind lets the extender bind the pods
+	Bind bool
+
+	// Ignorable lets kube-scheduler schedule the pods when the extender fails
+	Ignorable bool
+
+	// TLS of kube-scheduler calling the extender, the cert and key are the client
+	// certificate of kube-scheduler when the extender requires mutual TLS
+	CAFile   string
+	CertFile string
+	KeyFile  string
+	Insecure bool
+
+	// SchedulerName is the name of the scheduler profile, and of its leader lock
+	SchedulerName string
+
+	// PolicyConfigMap is the Policy of the versions whose KubeSchedulerConfiguration
+	// can not declare extenders
+	PolicyConfigMap string
+
+	// Namespace is the namespace of the Policy ConfigMap and of the leader lock
+	Namespace string
+}
+
+var (
+	// policyRemoved is the first version without the Policy API
+	policyRemoved = version.MustParseGeneric("1.23.0")
+
+	// configExtenders is the first version whose KubeSchedulerConfiguration
+	// declares the extenders
+	configExtenders = version.MustParseGeneric("1.18.0")
+)
+
+func parseVersion(v string) (*version.Version, error) {
+	if v == "" {
+		v = DefaultKubernetesVersion
+	}
+	ret, err := version.ParseGeneric(v)
+	if err != nil {
+		return nil, fmt.Errorf("invalid kubernetes version %q: %v", v, err)
+	}
+	if ret.LessThan(version.MustParseGeneric("1.11.0")) {
+		return nil, fmt.Errorf("kubernetes %s is not supported, the extender requires 1.11 or later", v)
+	}
+	return ret, nil
+}
+
+// RenderPolicy returns the legacy Policy JSON of the kube-scheduler version
+func RenderPolicy(opt *Options) ([]byte, error) {
+	v, err := parseVersion(opt.KubernetesVersion)
+	if err != nil {
+		return nil, err
+	}
+	if !v.LessThan(policyRemoved) {
+		return nil, fmt.Errorf("kubernetes %s removed the Policy API, render the KubeSchedulerConfiguration", v)
+	}
+
+	policy := &schedulerapiv1.Policy{
+		Predicates: predicatesOf(v),
+		Priorities: prioritiesOf(v),
+		ExtenderConfigs: []schedulerapiv1.ExtenderConfig{
+			policyExtender(opt),
+		},
+	}
+	policy.Kind = "Policy"
+	policy.APIVersion = "v1"
+
+	return json.MarshalIndent(policy, "", "  ")
+}
+
+// predicatesOf returns the default predicates of kube-scheduler
+func predicatesOf(v *version.Version) []schedulerapiv1.PredicatePolicy {
+	names := []string{
+		"NoVolumeZoneConflict",
+		"MaxEBSVolumeCount",
+		"MaxAzureDiskVolumeCount",
+		"NoDiskConflict",
+		"GeneralPredicates",
+		"PodToleratesNodeTaints",
+		"CheckVolumeBinding",
+		"MaxGCEPDVolumeCount",
+		"MatchInterPodAffinity",
+	}
+	if v.Major() == 1 && v.Minor() == 11 {
+		names = append(names, "CheckNodePIDPressure")
+	}
+	if v.LessThan(version.MustParseGeneric("1.12.0")) {
+		names = append(names, "CheckNodeCondition", "CheckNodeMemoryPressure", "CheckNodeDiskPressure")
+	}
+
+	ret := make([]schedulerapiv1.PredicatePolicy, 0, len(names))
+	for _, name := range names {
+		ret = append(ret, schedulerapiv1.PredicatePolicy{Name: name})
+	}
+	return ret
+}
+
+// prioritiesOf returns the default priorities of kube-scheduler
+func prioritiesOf(*version.Version) []schedulerapiv1.PriorityPolicy {
+	names := []string{
+		"SelectorSpreadPriority",
+		"InterPodAffinityPriority",
+		"LeastRequestedPriority",
+		"BalancedResourceAllocation",
+		"NodePreferAvoidPodsPriority",
+		"NodeAffinityPriority",
+		"TaintTolerationPriority",
+	}
+
+	ret := make([]schedulerapiv1.PriorityPolicy, 0, len(names))
+	for _, name := range names {
+		ret = append(ret, schedulerapiv1.PriorityPolicy{Name: name, Weight: 1})
+	}
+	return ret
+}
+
+func policyExtender(opt *Options) schedulerapiv1.ExtenderConfig {
+	c := scheduler.ExtenderCapabilities(opt.Bind)
+	ext := schedulerapiv1.ExtenderConfig{
+		URLPrefix:        urlPrefixOf(opt),
+		FilterVerb:       c.FilterVerb,
+		PrioritizeVerb:   c.PrioritizeVerb,
+		BindVerb:         c.BindVerb,
+		Weight:           weightOf(opt),
+		EnableHTTPS:      isHTTPS(opt),
+		HTTPTimeout:      httpTimeoutOf(opt),
+		NodeCacheCapable: c.NodeCacheCapable,
+		Ignorable:        opt.Ignorable,
+	}
+	for _, name := range c.ManagedResources {
+		ext.ManagedResources = append(ext.ManagedResources, schedulerapiv1.ExtenderManagedResource{
+			Name: corev1.ResourceName(name),
+		})
+	}
+	if ext.EnableHTTPS {
+		ext.TLSConfig = &schedulerapiv1.ExtenderTLSConfig{
+			Insecure: opt.Insecure,
+			CAFile:   opt.CAFile,
+			CertFile: opt.CertFile,
+			KeyFile:  opt.KeyFile,
+		}
+	}
+	return ext
+}
+
+// RenderConfig returns the KubeSchedulerConfiguration YAML of the kube-scheduler
+// version. The versions before 1.18 load the extender from the Policy ConfigMap.
+func RenderConfig(opt *Options) ([]byte, error) {
+	v, err := parseVersion(opt.KubernetesVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := map[string]interface{}{
+		"apiVersion": configAPIVersion(v),
+		"kind":       "KubeSchedulerConfiguration",
+	}
+
+	schedulerName := opt.SchedulerName
+	if schedulerName == "" {
+		schedulerName = "custom-scheduler"
+	}
+
+	if v.LessThan(configExtenders) {
+		if opt.PolicyConfigMap == "" || opt.Namespace == "" {
+			return nil, fmt.Errorf("kubernetes %s reads the extender from the Policy, a policy ConfigMap and namespace are required", v)
+		}
+		cfg["schedulerName"] = schedulerName
+		cfg["algorithmSource"] = map[string]interface{}{
+			"policy": map[string]interface{}{
+				"configMap": map[string]interface{}{
+					"namespace": opt.Namespace,
+					"name":      opt.PolicyConfigMap,
+				},
+			},
+		}
+		cfg["leaderElection"] = map[string]interface{}{
+			"leaderElect":         true,
+			"lockObjectName":      schedulerName,
+			"lockObjectNamespace": opt.Namespace,
+		}
+		return yaml.Marshal(cfg)
+	}
+
+	leaderElection := map[string]interface{}{
+		"leaderElect":  true,
+		"resourceName": schedulerName,
+	}
+	if opt.Namespace != "" {
+		leaderElection["resourceNamespace"] = opt.Namespace
+	}
+	cfg["leaderElection"] = leaderElection
+	cfg["profiles"] = []interface{}{
+		map[string]interface{}{"schedulerName": schedulerName},
+	}
+	cfg["extenders"] = []interface{}{configExtender(opt, v)}
+	return yaml.Marshal(cfg)
+}
+
+// configAPIVersion returns the api version of the KubeSchedulerConfiguration
+func configAPIVersion(v *version.Version) string {
+	switch {
+	case v.LessThan(version.MustParseGeneric("1.12.0")):
+		return "componentconfig/v1alpha1"
+	case v.LessThan(version.MustParseGeneric("1.18.0")):
+		return "kubescheduler.config.k8s.io/v1alpha1"
+	case v.LessThan(version.MustParseGeneric("1.19.0")):
+		return "kubescheduler.config.k8s.io/v1alpha2"
+	case v.LessThan(version.MustParseGeneric("1.22.0")):
+		return "kubescheduler.config.k8s.io/v1beta1"
+	case v.LessThan(version.MustParseGeneric("1.23.0")):
+		return "kubescheduler.config.k8s.io/v1beta2"
+	case v.LessThan(version.MustParseGeneric("1.25.0")):
+		return "kubescheduler.config.k8s.io/v1beta3"
+	default:
+		return "kubescheduler.config.k8s.io/v1"
+	}
+}
+
+// configExtender returns the extender of the KubeSchedulerConfiguration, whose
+// fields differ from the Policy: enableHTTPS, and httpTimeout is a duration
+// string since v1beta1
+func configExtender(opt *Options, v *version.Version) map[string]interface{} {
+	c := scheduler.ExtenderCapabilities(opt.Bind)
+	ext := map[string]interface{}{
+		"urlPrefix":        urlPrefixOf(opt),
+		"filterVerb":       c.FilterVerb,
+		"prioritizeVerb":   c.PrioritizeVerb,
+		"weight":           weightOf(opt),
+		"nodeCacheCapable": c.NodeCacheCapable,
+		"ignorable":        opt.Ignorable,
+		"enableHTTPS":      isHTTPS(opt),
+	}
+	if c.BindVerb != "" {
+		ext["bindVerb"] = c.BindVerb
+	}
+	if v.LessThan(version.MustParseGeneric("1.19.0")) {
+		ext["httpTimeout"] = int64(httpTimeoutOf(opt))
+	} else {
+		ext["httpTimeout"] = httpTimeoutOf(opt).String()
+	}
+
+	managed := []interface{}{}
+	for _, name := range c.ManagedResources {
+		managed = append(managed, map[string]interface{}{"name": name})
+	}
+	if len(managed) > 0 {
+		ext["managedResources"] = managed
+	}
+
+	if isHTTPS(opt) {
+		tlsConfig := map[string]interface{}{}
+		if opt.Insecure {
+			tlsConfig["insecure"] = true
+		}
+		if opt.CAFile != "" {
+			tlsConfig["caFile"] = opt.CAFile
+		}
+		if opt.CertFile != "" {
+			tlsConfig["certFile"] = opt.CertFile
+		}
+		if opt.KeyFile != "" {
+			tlsConfig["keyFile"] = opt.KeyFile
+		}
+		ext["tlsConfig"] = tlsConfig
+	}
+	return ext
+}
+
+func urlPrefixOf(opt *Options) string {
+	if opt.URLPrefix == "" {
+		return DefaultURLPrefix
+	}
+	return opt.URLPrefix
+}
+
+func weightOf(opt *Options) int {
+	if opt.Weight <= 0 {
+		return 1
+	}
+	return opt.Weight
+}
+
+func httpTimeoutOf(opt *Options) time.Duration {
+	if opt.HTTPTimeout <= 0 {
+		return DefaultHTTPTimeout
+	}
+	return opt.HTTPTimeout
+}
+
+func isHTTPS(opt *Options) bool {
+	return strings.HasPrefix(urlPrefixOf(opt), "https://")
+}
@@ -0,0 +1,178 @@
+package predicates
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/xkcp0324/custom-scheduler/pkg/observability/logging"
+	"github.com/xkcp0324/custom-scheduler/pkg/observe"
+	corev1 "k8s.io/api/core/v1"
+	schedulerapiv1 "k8s.io/kubernetes/pkg/scheduler/api/v1"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+const (
+	// DefaultCoLocationTopologyKey is the node label which groups nodes into zones
+	DefaultCoLocationTopologyKey = "failure-domain.beta.kubernetes.io/zone"
+)
+
+// CoLocationArgs are the arguments of the CoLocation predicate
+type CoLocationArgs struct {
+	// Weight of the CoLocation priority
+	Weight int
+
+	// TopologyKey is the node label whose value is shared by the nodes of a zone
+	TopologyKey string
+
+	// Peers is the policy app => peer apps, it applies to the pods without
+	// the colocate-with annotation
+	Peers map[string][]string
+}
+
+type coLocation struct {
+	mgr   manager.Manager
+	index *ReplicaIndex
+	args  CoLocationArgs
+}
+
+// NewCoLocation returns a Predicate which prefers the nodes, then the zones,
+// already running the peers of a pod
+func NewCoLocation(mgr manager.Manager, index *ReplicaIndex, args CoLocationArgs) Predicate {
+	if args.Weight <= 0 {
+		args.Weight = 1
+	}
+	if args.TopologyKey == "" {
+		args.TopologyKey = DefaultCoLocationTopologyKey
+	}
+
+	return &coLocation{
+		mgr:   mgr,
+		index: index,
+		args:  args,
+	}
+}
+
+func (c *coLocation) Name() string {
+	return "CoLocation"
+}
+
+// Weight returns the weight of the CoLocation priority
+func (c *coLocation) Weight() int {
+	return c.args.Weight
+}
+
+func (c *coLocation) Filter(ctx context.Context, instanceName string, pod *corev1.Pod, nodes []corev1.Node) ([]corev1.Node, schedulerapiv1.FailedNodesMap, error) {
+	return nodes, nil, nil
+}
+
+func (c *coLocation) Priority(ctx context.Context, pod *corev1.Pod, nodes []corev1.Node) (schedulerapiv1.HostPriorityList, error) {
+	result := schedulerapiv1.HostPriorityList{}
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("kube nodes is empty")
+	}
+
+	peers := c.peersOf(pod)
+	if len(peers) == 0 {
+		return zeroPriority(nodes), nil
+	}
+
+	ns := pod.GetNamespace()
+	nodeReplicas := make(map[string]int)
+	for _, peer := range peers {
+		replicas, err := c.index.ReplicasByNode(ctx, ns, peer, pod.UID)
+		if err != nil {
+			logging.FromContext(ctx).Error(err, "list pod err", "peer", peer)
+			return nil, err
+		}
+		for nodeName, count := range replicas {
+			nodeReplicas[nodeName] += count
+		}
+	}
+
+	candidates := nodesByName(nodes)
+
+	total := 0
+	zoneReplicas := make(map[string]int)
+	for nodeName, count := range nodeReplicas {
+		total += count
+		if zone := c.zoneOf(ctx, nodeName, candidates); zone != "" {
+			zoneReplicas[zone] += count
+		}
+	}
+
+	// a peer on the node always outweighs every peer in the zone
+	for _, node := range nodes {
+		score := nodeReplicas[node.Name] * (total + 1)
+		if zone := node.Labels[c.args.TopologyKey]; zone != "" {
+			score += zoneReplicas[zone]
+		}
+		result = append(result, schedulerapiv1.HostPriority{
+			Host:  node.Name,
+			Score: score,
+		})
+	}
+
+	logging.FromContext(ctx).V(3).Info("colocation priority", "peers", peers, "result", result)
+	return result, nil
+}
+
+// peersOf returns the peer apps of the pod, the colocate-with annotation takes
+// precedence over the policy
+func (c *coLocation) peersOf(pod *corev1.Pod) []string {
+	instanceName := pod.Labels[observe.ObserveMustLabelAppName]
+
+	var peers []string
+	if value, ok := pod.Annotations[observe.ObserveAnnotationCoLocateWith]; ok {
+		peers = splitList(value)
+	} else if instanceName != "" {
+		peers = c.args.Peers[instanceName]
+	}
+
+	var ret []string
+	for _, peer := range peers {
+		if peer != instanceName {
+			ret = append(ret, peer)
+		}
+	}
+	return ret
+}
+
+func (c *coLocation) zoneOf(ctx context.Context, nodeName string, candidates map[string]*corev1.Node) string {
+	node, err := getNode(ctx, c.mgr, nodeName, candidates)
+	if err != nil {
+		logging.FromContext(ctx).V(4).Info("get node err", "node", nodeName, "err", err.Error())
+		return ""
+	}
+	return node.Labels[c.args.TopologyKey]
+}
+
+// ParseCoLocationPeers parses a policy of the form "app1=peer1,peer2;app2=peer3"
+func ParseCoLocationPeers(policy string) (map[string][]string, error) {
+	peers := make(map[string][]string)
+	for _, entry := range strings.Split(policy, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		kv := strings.SplitN(entry, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+			return nil, fmt.Errorf("invalid colocation policy entry: %q", entry)
+		}
+		app := strings.TrimSpace(kv[0])
+		peers[app] = append(peers[app], splitList(kv[1])...)
+	}
+
+	return peers, nil
+}
+
+func splitList(value string) []string {
+	var ret []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			ret = append(ret, item)
+		}
+	}
+	return ret
+}
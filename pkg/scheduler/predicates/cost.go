@@ -0,0 +1,227 @@
+package predicates
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/xkcp0324/custom-scheduler/pkg/observability/logging"
+	"github.com/xkcp0324/custom-scheduler/pkg/observe"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	schedulerapiv1 "k8s.io/kubernetes/pkg/scheduler/api/v1"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+const (
+	// DefaultInterruptibleSelector selects the spot nodes
+	DefaultInterruptibleSelector = "node.kubernetes.io/lifecycle=spot"
+
+	// DefaultMaxInterruptibleShare is the default max share of the replicas of an
+	// app running on interruptible nodes
+	DefaultMaxInterruptibleShare = 0.5
+
+	// costPriorityScale keeps the precision of the relative cost in the int score
+	costPriorityScale = 1000
+)
+
+// CostArgs are the arguments of the Cost predicate
+type CostArgs struct {
+	// Weight of the Cost priority
+	Weight int
+
+	// PriceLabel is the node label holding the price of the node
+	PriceLabel string
+
+	// InterruptibleSelector is the label selector of the interruptible nodes
+	InterruptibleSelector string
+
+	// MaxInterruptibleShare is the max share of the replicas of an app on the
+	// interruptible nodes, in [0, 1]
+	MaxInterruptibleShare float64
+}
+
+type cost struct {
+	mgr           manager.Manager
+	index         *ReplicaIndex
+	args          CostArgs
+	interruptible labels.Selector
+}
+
+// NewCost returns a Predicate which filters out the nodes about to be terminated,
+// keeps the share of replicas on interruptible nodes under a limit and prefers the
+// nodes running the pod at the lowest price
+func NewCost(mgr manager.Manager, index *ReplicaIndex, args CostArgs) (Predicate, error) {
+	if args.Weight <= 0 {
+		args.Weight = 1
+	}
+	if args.PriceLabel == "" {
+		args.PriceLabel = observe.ObserveLabelNodePrice
+	}
+	if args.InterruptibleSelector == "" {
+		args.InterruptibleSelector = DefaultInterruptibleSelector
+	}
+	if args.MaxInterruptibleShare < 0 || args.MaxInterruptibleShare > 1 {
+		return nil, fmt.Errorf("max interruptible share %v is out of [0, 1]", args.MaxInterruptibleShare)
+	}
+
+	selector, err := labels.Parse(args.InterruptibleSelector)
+	if err != nil {
+		return nil, fmt.Errorf("parse interruptible selector %q err: %v", args.InterruptibleSelector, err)
+	}
+
+	return &cost{
+		mgr:           mgr,
+		index:         index,
+		args:          args,
+		interruptible: selector,
+	}, nil
+}
+
+func (c *cost) Name() string {
+	return "Cost"
+}
+
+// Weight returns the weight of the Cost priority
+func (c *cost) Weight() int {
+	return c.args.Weight
+}
+
+func (c *cost) Filter(ctx context.Context, instanceName string, pod *corev1.Pod, nodes []corev1.Node) ([]corev1.Node, schedulerapiv1.FailedNodesMap, error) {
+	var ret []corev1.Node
+	failed := schedulerapiv1.FailedNodesMap{}
+	for _, node := range nodes {
+		if _, ok := node.Annotations[observe.ObserveAnnotationTerminationNotice]; ok {
+			logging.FromContext(ctx).V(3).Info("node has a termination notice", "node", node.Name)
+			failed[node.Name] = ReasonTerminationNotice
+			continue
+		}
+		ret = append(ret, node)
+	}
+
+	if instanceName == "" {
+		return ret, failed, nil
+	}
+
+	allowed, err := c.allowInterruptible(ctx, pod, instanceName, ret)
+	if err != nil {
+		return nil, nil, err
+	}
+	if allowed {
+		return ret, failed, nil
+	}
+
+	var onDemand []corev1.Node
+	for _, node := range ret {
+		if c.isInterruptible(&node) {
+			failed[node.Name] = ReasonInterruptibleShare
+			continue
+		}
+		onDemand = append(onDemand, node)
+	}
+	logging.FromContext(ctx).V(3).Info("app reached the interruptible share", "app", instanceName, "share", c.args.MaxInterruptibleShare)
+	return onDemand, failed, nil
+}
+
+// allowInterruptible returns whether one more replica of the app fits on the
+// interruptible nodes. The share is counted over the existing replicas and the
+// candidate, rounded up, so the first replica of an app may run on them unless
+// the max share is 0.
+func (c *cost) allowInterruptible(ctx context.Context, pod *corev1.Pod, instanceName string, nodes []corev1.Node) (bool, error) {
+	replicas, err := c.index.ReplicasByNode(ctx, pod.GetNamespace(), instanceName, pod.UID)
+	if err != nil {
+		logging.FromContext(ctx).Error(err, "list pod err")
+		return false, err
+	}
+
+	candidates := nodesByName(nodes)
+	total, interruptible := 0, 0
+	for nodeName, count := range replicas {
+		total += count
+		node, err := getNode(ctx, c.mgr, nodeName, candidates)
+		if err != nil {
+			logging.FromContext(ctx).V(4).Info("get node err", "node", nodeName, "err", err.Error())
+			continue
+		}
+		if c.isInterruptible(node) {
+			interruptible += count
+		}
+	}
+
+	// the candidate is one more replica, and one more on the interruptible nodes
+	maxInterruptible := math.Ceil(c.args.MaxInterruptibleShare * float64(total+1))
+	return float64(interruptible+1) <= maxInterruptible, nil
+}
+
+func (c *cost) isInterruptible(node *corev1.Node) bool {
+	return c.interruptible.Matches(labels.Set(node.Labels))
+}
+
+func (c *cost) Priority(ctx context.Context, pod *corev1.Pod, nodes []corev1.Node) (schedulerapiv1.HostPriorityList, error) {
+	result := schedulerapiv1.HostPriorityList{}
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("kube nodes is empty")
+	}
+
+	requests := podRequests(pod)
+	costs := make([]float64, len(nodes))
+	maxCost := 0.0
+	for i := range nodes {
+		costs[i] = c.podCost(requests, &nodes[i])
+		if costs[i] > maxCost {
+			maxCost = costs[i]
+		}
+	}
+
+	// the cheapest node gets the highest score, a node without price is neutral
+	// and scores the midpoint
+	for i, node := range nodes {
+		score := costPriorityScale / 2
+		if costs[i] >= 0 {
+			score = costPriorityScale
+			if maxCost > 0 {
+				score = int(math.Round((maxCost - costs[i]) / maxCost * costPriorityScale))
+			}
+		}
+		result = append(result, schedulerapiv1.HostPriority{
+			Host:  node.Name,
+			Score: score,
+		})
+	}
+
+	logging.FromContext(ctx).V(3).Info("cost priority", "result", result)
+	return result, nil
+}
+
+// podCost returns the price of the share of the node requested by the pod, the
+// share is the largest of the requested cpu and memory ratios. It returns -1 when
+// the node has no valid price.
+func (c *cost) podCost(requests corev1.ResourceList, node *corev1.Node) float64 {
+	price, err := strconv.ParseFloat(node.Labels[c.args.PriceLabel], 64)
+	if err != nil || price < 0 {
+		return -1
+	}
+
+	share := 0.0
+	for _, name := range []corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceMemory} {
+		allocatable, ok := node.Status.Allocatable[name]
+		if !ok || allocatable.IsZero() {
+			continue
+		}
+		request := requests[name]
+		if ratio := float64(request.MilliValue()) / float64(allocatable.MilliValue()); ratio > share {
+			share = ratio
+		}
+	}
+
+	// a pod without requests is charged by the price per cpu
+	if share == 0 {
+		if cpu, ok := node.Status.Allocatable[corev1.ResourceCPU]; ok && !cpu.IsZero() {
+			share = 1000 / float64(cpu.MilliValue())
+		} else {
+			share = 1
+		}
+	}
+	return price * share
+}
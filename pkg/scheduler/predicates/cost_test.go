@@ -0,0 +1,131 @@
+package predicates
+
+import (
+	"context"
+	"testing"
+
+	"github.com/xkcp0324/custom-scheduler/pkg/observe"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// fakeManager serves the objects of a fake client as the manager cache
+type fakeManager struct {
+	manager.Manager
+	client client.Client
+}
+
+func (m *fakeManager) GetClient() client.Client {
+	return m.client
+}
+
+func newCost(t *testing.T, objs ...runtime.Object) Predicate {
+	mgr := &fakeManager{client: fake.NewFakeClient(objs...)}
+	c, err := NewCost(mgr, NewReplicaIndex(mgr, nil), CostArgs{
+		MaxInterruptibleShare: DefaultMaxInterruptibleShare,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func costNode(name string, spot bool, price string) corev1.Node {
+	node := corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{}}}
+	if spot {
+		node.Labels["node.kubernetes.io/lifecycle"] = "spot"
+	}
+	if price != "" {
+		node.Labels[observe.ObserveLabelNodePrice] = price
+	}
+	return node
+}
+
+func replicaPod(name, nodeName string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+			UID:       types.UID(name),
+			Labels:    map[string]string{observe.ObserveMustLabelAppName: "web"},
+		},
+		Spec: corev1.PodSpec{NodeName: nodeName},
+	}
+}
+
+func TestCostFilterInterruptibleShare(t *testing.T) {
+	spot := costNode("spot", true, "")
+	onDemand := costNode("on-demand", false, "")
+
+	for _, tc := range []struct {
+		name     string
+		replicas []runtime.Object
+		wantSpot bool
+	}{
+		{
+			name:     "first replica",
+			wantSpot: true,
+		},
+		{
+			name:     "second replica, the first on spot",
+			replicas: []runtime.Object{&spot, replicaPod("web-0", "spot")},
+			wantSpot: false,
+		},
+		{
+			name:     "second replica, the first on demand",
+			replicas: []runtime.Object{&onDemand, replicaPod("web-0", "on-demand")},
+			wantSpot: true,
+		},
+		{
+			name:     "scheduled pod is not counted against itself",
+			replicas: []runtime.Object{&spot, replicaPod("web-1", "spot")},
+			wantSpot: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := newCost(t, tc.replicas...)
+			nodes, failed, err := c.Filter(context.Background(), "web", replicaPod("web-1", ""), []corev1.Node{spot, onDemand})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			gotSpot := false
+			for _, node := range nodes {
+				if node.Name == spot.Name {
+					gotSpot = true
+				}
+			}
+			if gotSpot != tc.wantSpot {
+				t.Errorf("spot node kept = %v, want %v, failed %v", gotSpot, tc.wantSpot, failed)
+			}
+		})
+	}
+}
+
+func TestCostPriorityUnpriced(t *testing.T) {
+	c := newCost(t)
+	result, err := c.Priority(context.Background(), replicaPod("web-0", ""), []corev1.Node{
+		costNode("cheap", false, "1"),
+		costNode("expensive", false, "2"),
+		costNode("unpriced", false, ""),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]int{
+		"cheap":     costPriorityScale / 2,
+		"expensive": 0,
+		"unpriced":  costPriorityScale / 2,
+	}
+	for _, hp := range result {
+		if hp.Score != want[hp.Host] {
+			t.Errorf("score of %s = %d, want %d", hp.Host, hp.Score, want[hp.Host])
+		}
+	}
+}
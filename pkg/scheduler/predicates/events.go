@@ -0,0 +1,148 @@
+package predicates
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+const (
+	// EventReasonNodesRejected is emitted when the predicates reject all or most nodes
+	EventReasonNodesRejected = "ExtenderRejectedNodes"
+
+	// EventReasonFailOpen is emitted when a failing predicate is skipped
+	EventReasonFailOpen = "ExtenderFailOpen"
+
+	// EventReasonConstraintRelaxed is emitted when a hard constraint does not apply to a pod
+	EventReasonConstraintRelaxed = "ExtenderConstraintRelaxed"
+
+	// DefaultEventInterval is the default min interval between two events of a
+	// pod with the same reason
+	DefaultEventInterval = time.Minute
+)
+
+// Relaxer is implemented by the predicates whose hard constraint can be lifted
+// for a single pod
+type Relaxer interface {
+	// Relaxed returns why the constraint does not apply to the pod, empty when it applies
+	Relaxed(pod *corev1.Pod) string
+}
+
+type explainKey struct{}
+
+// WithExplain marks the context of an explanation, the predicates record no
+// event on the explained pod
+func WithExplain(ctx context.Context) context.Context {
+	return context.WithValue(ctx, explainKey{}, true)
+}
+
+func isExplain(ctx context.Context) bool {
+	explain, _ := ctx.Value(explainKey{}).(bool)
+	return explain
+}
+
+type rateLimitedRecorder struct {
+	record.EventRecorder
+	interval time.Duration
+
+	lock sync.Mutex
+	// uid/reason => time of the last event
+	last map[string]time.Time
+}
+
+// NewRateLimitedRecorder returns an EventRecorder which drops the events of an
+// object repeating a reason within the interval. The expired keys are pruned
+// every interval by a runnable of the manager.
+func NewRateLimitedRecorder(mgr manager.Manager, recorder record.EventRecorder, interval time.Duration) (record.EventRecorder, error) {
+	if interval <= 0 {
+		interval = DefaultEventInterval
+	}
+
+	r := &rateLimitedRecorder{
+		EventRecorder: recorder,
+		interval:      interval,
+		last:          make(map[string]time.Time),
+	}
+	if err := mgr.Add(r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Start prunes the expired keys every interval until the stop channel is closed
+func (r *rateLimitedRecorder) Start(stopCh <-chan struct{}) error {
+	wait.Until(r.prune, r.interval, stopCh)
+	return nil
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable, every replica
+// records the events of the requests it serves
+func (r *rateLimitedRecorder) NeedLeaderElection() bool {
+	return false
+}
+
+func (r *rateLimitedRecorder) prune() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	now := time.Now()
+	for k, t := range r.last {
+		if now.Sub(t) >= r.interval {
+			delete(r.last, k)
+		}
+	}
+}
+
+func (r *rateLimitedRecorder) allow(object runtime.Object, reason string) bool {
+	accessor, err := meta.Accessor(object)
+	if err != nil {
+		return true
+	}
+
+	key := string(accessor.GetUID()) + "/" + reason
+	if accessor.GetUID() == "" {
+		key = accessor.GetNamespace() + "/" + accessor.GetName() + "/" + reason
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	now := time.Now()
+	if t, ok := r.last[key]; ok && now.Sub(t) < r.interval {
+		return false
+	}
+	r.last[key] = now
+	return true
+}
+
+func (r *rateLimitedRecorder) Event(object runtime.Object, eventtype, reason, message string) {
+	if r.allow(object, reason) {
+		r.EventRecorder.Event(object, eventtype, reason, message)
+	}
+}
+
+func (r *rateLimitedRecorder) Eventf(object runtime.Object, eventtype, reason, messageFmt string, args ...interface{}) {
+	if r.allow(object, reason) {
+		r.EventRecorder.Eventf(object, eventtype, reason, messageFmt, args...)
+	}
+}
+
+func (r *rateLimitedRecorder) PastEventf(object runtime.Object, timestamp metav1.Time, eventtype, reason, messageFmt string, args ...interface{}) {
+	if r.allow(object, reason) {
+		r.EventRecorder.PastEventf(object, timestamp, eventtype, reason, messageFmt, args...)
+	}
+}
+
+func (r *rateLimitedRecorder) AnnotatedEventf(object runtime.Object, annotations map[string]string, eventtype, reason, messageFmt string, args ...interface{}) {
+	if r.allow(object, reason) {
+		r.EventRecorder.AnnotatedEventf(object, annotations, eventtype, reason, messageFmt, args...)
+	}
+}
@@ -7,12 +7,11 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/record"
-	"k8s.io/klog"
 	schedulerapiv1 "k8s.io/kubernetes/pkg/scheduler/api/v1"
-	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sync"
 	"github.com/xkcp0324/custom-scheduler/pkg/observe"
+	"github.com/xkcp0324/custom-scheduler/pkg/observability/logging"
 )
 
 
@@ -22,27 +21,40 @@ type ha struct {
 	kubeCli  kubernetes.Interface
 	mgr      manager.Manager
 	recorder record.EventRecorder
+	index    *ReplicaIndex
 }
 
 // NewHA returns a Predicate
-func NewHA(kubeCli kubernetes.Interface, mgr manager.Manager) Predicate {
+func NewHA(kubeCli kubernetes.Interface, mgr manager.Manager, index *ReplicaIndex, recorder record.EventRecorder) Predicate {
 	h := &ha{
-		kubeCli: kubeCli,
-		mgr:     mgr,
+		kubeCli:  kubeCli,
+		mgr:      mgr,
+		recorder: recorder,
+		index:    index,
 	}
 
 	return h
 }
 
+// HAScore returns the HighAvailability score of a node running the given number
+// of replicas of an app, the fewer replicas the higher the score
+func HAScore(replicas int) int {
+	score := 100 - replicas
+	if score < 0 {
+		score = 0
+	}
+	return score
+}
+
 func (h *ha) Name() string {
 	return "HighAvailability"
 }
 
-func (h *ha) Filter(instanceName string, pod *corev1.Pod, nodes []corev1.Node) ([]corev1.Node, error) {
-	return nodes, nil
+func (h *ha) Filter(ctx context.Context, instanceName string, pod *corev1.Pod, nodes []corev1.Node) ([]corev1.Node, schedulerapiv1.FailedNodesMap, error) {
+	return nodes, nil, nil
 }
 
-func (h *ha) Priority(pod *corev1.Pod, nodes []corev1.Node) (schedulerapiv1.HostPriorityList, error) {
+func (h *ha) Priority(ctx context.Context, pod *corev1.Pod, nodes []corev1.Node) (schedulerapiv1.HostPriorityList, error) {
 	h.lock.Lock()
 	defer h.lock.Unlock()
 
@@ -54,7 +66,7 @@ func (h *ha) Priority(pod *corev1.Pod, nodes []corev1.Node) (schedulerapiv1.Host
 
 	instanceName, ok := pod.Labels[observe.ObserveMustLabelAppName]
 	if !ok {
-		klog.Errorf("no find pod label: %s", observe.ObserveMustLabelAppName)
+		logging.FromContext(ctx).Info("no find pod label", "label", observe.ObserveMustLabelAppName)
 		return nil, fmt.Errorf("no find pod label")
 	}
 
@@ -66,44 +78,29 @@ func (h *ha) Priority(pod *corev1.Pod, nodes []corev1.Node) (schedulerapiv1.Host
 		return result, nil
 	}
 
-	// podList, err := h.podLister.Pods(ns).List(labels.SelectorFromSet(labels.Set{"app": instanceName}))
-	// if err != nil {
-	// 	klog.Errorf("list pod err: %+v", err)
-	// 	return nil, err
-	// }
-
-	cl := h.mgr.GetClient()
-	podList := &corev1.PodList{}
-
-	// err := cl.List(context.Background(), client.InNamespace(ns).MatchingLabels(map[string]string{"app": instanceName}), podList)
-	err := cl.List(context.Background(), podList, client.InNamespace(ns), client.MatchingLabels{"app": instanceName})
+	replicas, err := h.index.ReplicasByNode(ctx, ns, instanceName, pod.UID)
 	if err != nil {
-		klog.Errorf("list pod err: %+v", err)
+		logging.FromContext(ctx).Error(err, "list pod err")
 		return nil, err
 	}
 
+	spread := false
 	for _, node := range nodes {
+		if replicas[node.Name] == 0 {
+			spread = true
+		}
 		result = append(result, schedulerapiv1.HostPriority{
 			Host:  node.Name,
-			Score: 100,
+			Score: HAScore(replicas[node.Name]),
 		})
 	}
 
-	for _, pod := range podList.Items {
-		nodeName := pod.Spec.NodeName
-		if nodeName == "" {
-			continue
-		}
-
-		for i := range result {
-			if result[i].Host == nodeName {
-				if result[i].Score > 0 {
-					result[i].Score--
-				}
-			}
-		}
+	// the replicas can not be spread further, the pod joins a node running one
+	if !spread && !isExplain(ctx) {
+		h.recorder.Eventf(pod, corev1.EventTypeWarning, EventReasonConstraintRelaxed,
+			"predicate %s is relaxed: each of the %d nodes already runs a replica of %s", h.Name(), len(nodes), instanceName)
 	}
 
-	klog.V(3).Infof("result: %+v", result)
+	logging.FromContext(ctx).V(3).Info("ha priority", "result", result)
 	return result, nil
 }
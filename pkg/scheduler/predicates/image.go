@@ -0,0 +1,141 @@
+package predicates
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/xkcp0324/custom-scheduler/pkg/observability/logging"
+	corev1 "k8s.io/api/core/v1"
+	schedulerapiv1 "k8s.io/kubernetes/pkg/scheduler/api/v1"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+const (
+	mb int64 = 1024 * 1024
+
+	// images smaller than minImageThreshold are cheap to pull, a node scores
+	// nothing for them
+	minImageThreshold int64 = 23 * mb
+
+	// maxImageThreshold caps the score of every container
+	maxImageThreshold int64 = 1000 * mb
+)
+
+// ImageLocalityArgs are the arguments of the ImageLocality predicate
+type ImageLocalityArgs struct {
+	// Weight of the ImageLocality priority
+	Weight int
+}
+
+type imageLocality struct {
+	mgr  manager.Manager
+	args ImageLocalityArgs
+}
+
+// NewImageLocality returns a Predicate which prefers the nodes already holding
+// the images of the pod
+func NewImageLocality(mgr manager.Manager, args ImageLocalityArgs) Predicate {
+	if args.Weight <= 0 {
+		args.Weight = 1
+	}
+
+	return &imageLocality{
+		mgr:  mgr,
+		args: args,
+	}
+}
+
+func (il *imageLocality) Name() string {
+	return "ImageLocality"
+}
+
+// Weight returns the weight of the ImageLocality priority
+func (il *imageLocality) Weight() int {
+	return il.args.Weight
+}
+
+func (il *imageLocality) Filter(ctx context.Context, instanceName string, pod *corev1.Pod, nodes []corev1.Node) ([]corev1.Node, schedulerapiv1.FailedNodesMap, error) {
+	return nodes, nil, nil
+}
+
+// Priority scores a node by the size of the pod images present on it. The size of
+// an image is scaled by the share of the nodes of the cluster holding it, so an
+// image present on a single node does not pull every replica to that node.
+func (il *imageLocality) Priority(ctx context.Context, pod *corev1.Pod, nodes []corev1.Node) (schedulerapiv1.HostPriorityList, error) {
+	result := schedulerapiv1.HostPriorityList{}
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("kube nodes is empty")
+	}
+
+	// the spread is counted over the cluster, the candidates are already filtered
+	nodeList := &corev1.NodeList{}
+	if err := cacheList(ctx, il.mgr, nodeList); err != nil {
+		return nil, err
+	}
+	totalNodes := len(nodeList.Items)
+	if totalNodes == 0 {
+		return nil, fmt.Errorf("no node in the cache")
+	}
+
+	// image => number of nodes holding it
+	spread := make(map[string]int)
+	for i := range nodeList.Items {
+		for name := range nodeImageSizes(&nodeList.Items[i]) {
+			spread[name]++
+		}
+	}
+
+	containers := len(pod.Spec.Containers)
+	for _, node := range nodes {
+		sizes := nodeImageSizes(&node)
+		var sum int64
+		for _, container := range pod.Spec.Containers {
+			name := normalizedImageName(container.Image)
+			if size, ok := sizes[name]; ok {
+				sum += size * int64(spread[name]) / int64(totalNodes)
+			}
+		}
+
+		result = append(result, schedulerapiv1.HostPriority{
+			Host:  node.Name,
+			Score: imageScore(sum, containers),
+		})
+	}
+
+	logging.FromContext(ctx).V(3).Info("image locality priority", "result", result)
+	return result, nil
+}
+
+// imageScore maps the scaled image size into [0, 100]
+func imageScore(sum int64, containers int) int {
+	maxThreshold := maxImageThreshold * int64(containers)
+	if sum < minImageThreshold {
+		sum = minImageThreshold
+	} else if sum > maxThreshold {
+		sum = maxThreshold
+	}
+	if maxThreshold <= minImageThreshold {
+		return 0
+	}
+	return int(100 * (sum - minImageThreshold) / (maxThreshold - minImageThreshold))
+}
+
+// nodeImageSizes returns image name => size of the images on the node
+func nodeImageSizes(node *corev1.Node) map[string]int64 {
+	sizes := make(map[string]int64)
+	for _, image := range node.Status.Images {
+		for _, name := range image.Names {
+			sizes[normalizedImageName(name)] = image.SizeBytes
+		}
+	}
+	return sizes
+}
+
+// normalizedImageName appends the latest tag to an image without tag or digest
+func normalizedImageName(name string) string {
+	if strings.LastIndex(name, ":") <= strings.LastIndex(name, "/") && !strings.Contains(name, "@") {
+		name = name + ":latest"
+	}
+	return name
+}
@@ -0,0 +1,236 @@
+package predicates
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/xkcp0324/custom-scheduler/pkg/config"
+	"github.com/xkcp0324/custom-scheduler/pkg/observability/logging"
+	"github.com/xkcp0324/custom-scheduler/pkg/observe"
+	"github.com/xkcp0324/custom-scheduler/pkg/scheduler/reservation"
+	corev1 "k8s.io/api/core/v1"
+	schedulerapiv1 "k8s.io/kubernetes/pkg/scheduler/api/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/yaml"
+)
+
+// PodCountArgs are the arguments of the PodCount predicate
+type PodCountArgs struct {
+	// ConfigFile is the path of the PodCountConfig, the predicate keeps every
+	// node when it is empty
+	ConfigFile string
+}
+
+// PodCountConfig holds the cluster default limits and the per namespace overrides
+type PodCountConfig struct {
+	Defaults   PodCountLimits              `json:"defaults"`
+	Namespaces map[string]PodCountOverride `json:"namespaces,omitempty"`
+}
+
+// PodCountLimits caps the pods on a single node, 0 means unlimited
+type PodCountLimits struct {
+	// MaxPodsPerNamespace caps the pods of the namespace on a node
+	MaxPodsPerNamespace int `json:"maxPodsPerNamespace,omitempty"`
+
+	// MaxPodsPerWorkload caps the replicas of an app on a node
+	MaxPodsPerWorkload int `json:"maxPodsPerWorkload,omitempty"`
+}
+
+// PodCountOverride replaces the default limits it sets for a namespace, a limit
+// set to 0 lifts the default
+type PodCountOverride struct {
+	MaxPodsPerNamespace *int `json:"maxPodsPerNamespace,omitempty"`
+	MaxPodsPerWorkload  *int `json:"maxPodsPerWorkload,omitempty"`
+}
+
+type podCount struct {
+	lock         sync.RWMutex
+	mgr          manager.Manager
+	cfg          *PodCountConfig
+	reloader     *config.Reloader
+	reservations reservation.Store
+}
+
+// NewPodCount returns a Predicate which filters out the nodes already running
+// the max number of pods of the namespace or of the app of a pod. The pods
+// reserved on a node count as running there.
+func NewPodCount(mgr manager.Manager, args PodCountArgs, reservations reservation.Store) (Predicate, error) {
+	pc := &podCount{
+		mgr:          mgr,
+		reservations: reservations,
+	}
+
+	if args.ConfigFile == "" {
+		return pc, nil
+	}
+
+	pc.reloader = config.NewReloader(args.ConfigFile, config.DefaultReloadInterval, pc.load)
+	if err := pc.reloader.Load(); err != nil {
+		return nil, err
+	}
+	if err := mgr.Add(pc.reloader); err != nil {
+		return nil, err
+	}
+
+	return pc, nil
+}
+
+func (pc *podCount) Name() string {
+	return "PodCount"
+}
+
+// ConfigErr returns the error of the last load of the config file
+func (pc *podCount) ConfigErr() error {
+	if pc.reloader == nil {
+		return nil
+	}
+	return pc.reloader.Err()
+}
+
+func (pc *podCount) load(data []byte) error {
+	cfg := &PodCountConfig{}
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return err
+	}
+
+	if cfg.Defaults.MaxPodsPerNamespace < 0 || cfg.Defaults.MaxPodsPerWorkload < 0 {
+		return fmt.Errorf("default limits must not be negative")
+	}
+	for ns, override := range cfg.Namespaces {
+		if negative(override.MaxPodsPerNamespace) || negative(override.MaxPodsPerWorkload) {
+			return fmt.Errorf("limits of namespace %s must not be negative", ns)
+		}
+	}
+
+	pc.lock.Lock()
+	defer pc.lock.Unlock()
+	pc.cfg = cfg
+	return nil
+}
+
+// limitsOf returns the limits of the namespace, the overrides merged on the defaults
+func (pc *podCount) limitsOf(ns string) (PodCountLimits, bool) {
+	pc.lock.RLock()
+	defer pc.lock.RUnlock()
+
+	if pc.cfg == nil {
+		return PodCountLimits{}, false
+	}
+
+	limits := pc.cfg.Defaults
+	if override, ok := pc.cfg.Namespaces[ns]; ok {
+		if override.MaxPodsPerNamespace != nil {
+			limits.MaxPodsPerNamespace = *override.MaxPodsPerNamespace
+		}
+		if override.MaxPodsPerWorkload != nil {
+			limits.MaxPodsPerWorkload = *override.MaxPodsPerWorkload
+		}
+	}
+	return limits, true
+}
+
+func negative(limit *int) bool {
+	return limit != nil && *limit < 0
+}
+
+func (pc *podCount) Filter(ctx context.Context, instanceName string, pod *corev1.Pod, nodes []corev1.Node) ([]corev1.Node, schedulerapiv1.FailedNodesMap, error) {
+	ns := pod.GetNamespace()
+	limits, ok := pc.limitsOf(ns)
+	if !ok || limits.MaxPodsPerNamespace == 0 && (limits.MaxPodsPerWorkload == 0 || instanceName == "") {
+		return nodes, nil, nil
+	}
+
+	nsPods, appPods, err := pc.count(ctx, instanceName, pod, listReservations(ctx, pc.reservations))
+	if err != nil {
+		logging.FromContext(ctx).Error(err, "list pod err")
+		return nil, nil, err
+	}
+
+	logger := logging.FromContext(ctx)
+	var ret []corev1.Node
+	failed := schedulerapiv1.FailedNodesMap{}
+	for _, node := range nodes {
+		switch {
+		case limits.MaxPodsPerNamespace > 0 && nsPods[node.Name] >= limits.MaxPodsPerNamespace:
+			logger.V(4).Info("node reached the pod limit of the namespace",
+				"node", node.Name, "pods", nsPods[node.Name], "limit", limits.MaxPodsPerNamespace)
+			failed[node.Name] = ReasonNamespacePodLimit
+		case limits.MaxPodsPerWorkload > 0 && instanceName != "" && appPods[node.Name] >= limits.MaxPodsPerWorkload:
+			logger.V(4).Info("node reached the pod limit of the app",
+				"node", node.Name, "app", instanceName, "pods", appPods[node.Name], "limit", limits.MaxPodsPerWorkload)
+			failed[node.Name] = ReasonWorkloadPodLimit
+		default:
+			ret = append(ret, node)
+		}
+	}
+
+	logger.V(3).Info("pod count filter", "limits", limits, "nodes", GetNodeNames(ret))
+	return ret, failed, nil
+}
+
+// Admit checks the limits again when the pod is bound to the node, against the
+// reservations the store holds at that time. Two replicas filtering at once both
+// let the last free slot of a node through, only one of them may reserve it.
+func (pc *podCount) Admit(ctx context.Context, pod *corev1.Pod, nodeName string, reserved []reservation.Reservation) error {
+	instanceName := pod.Labels[observe.ObserveMustLabelAppName]
+	limits, ok := pc.limitsOf(pod.GetNamespace())
+	if !ok || limits.MaxPodsPerNamespace == 0 && (limits.MaxPodsPerWorkload == 0 || instanceName == "") {
+		return nil
+	}
+
+	nsPods, appPods, err := pc.count(ctx, instanceName, pod, reserved)
+	if err != nil {
+		return err
+	}
+	switch {
+	case limits.MaxPodsPerNamespace > 0 && nsPods[nodeName] >= limits.MaxPodsPerNamespace:
+		return fmt.Errorf("%s: %s", pc.Name(), ReasonNamespacePodLimit)
+	case limits.MaxPodsPerWorkload > 0 && instanceName != "" && appPods[nodeName] >= limits.MaxPodsPerWorkload:
+		return fmt.Errorf("%s: %s", pc.Name(), ReasonWorkloadPodLimit)
+	}
+	return nil
+}
+
+// count returns node name => pods of the namespace of the pod, and of its app.
+// The pods bound in the cache and the reservations of the others are counted,
+// the pod itself is not.
+func (pc *podCount) count(ctx context.Context, instanceName string, pod *corev1.Pod, reserved []reservation.Reservation) (map[string]int, map[string]int, error) {
+	ns := pod.GetNamespace()
+	podList := &corev1.PodList{}
+	if err := cacheList(ctx, pc.mgr, podList, client.InNamespace(ns)); err != nil {
+		return nil, nil, err
+	}
+
+	nsPods := make(map[string]int)
+	appPods := make(map[string]int)
+	bound := map[string]bool{string(pod.UID): true}
+	for _, p := range podList.Items {
+		if p.Spec.NodeName == "" || isTerminated(&p) || p.UID == pod.UID {
+			continue
+		}
+		nsPods[p.Spec.NodeName]++
+		if instanceName != "" && p.Labels[observe.ObserveMustLabelAppName] == instanceName {
+			appPods[p.Spec.NodeName]++
+		}
+		bound[string(p.UID)] = true
+	}
+	for nodeName, n := range reservedByNode(reserved, bound, func(r *reservation.Reservation) bool {
+		return r.Namespace == ns
+	}) {
+		nsPods[nodeName] += n
+	}
+	if instanceName != "" {
+		for nodeName, n := range reservedByNode(reserved, bound, func(r *reservation.Reservation) bool {
+			return r.Namespace == ns && r.App == instanceName
+		}) {
+			appPods[nodeName] += n
+		}
+	}
+	return nsPods, appPods, nil
+}
+
+func (pc *podCount) Priority(ctx context.Context, pod *corev1.Pod, nodes []corev1.Node) (schedulerapiv1.HostPriorityList, error) {
+	return zeroPriority(nodes), nil
+}
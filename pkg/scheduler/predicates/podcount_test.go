@@ -0,0 +1,38 @@
+package predicates
+
+import "testing"
+
+func TestPodCountOverrides(t *testing.T) {
+	pc := &podCount{}
+	err := pc.load([]byte(`
+defaults:
+  maxPodsPerNamespace: 20
+  maxPodsPerWorkload: 2
+namespaces:
+  unlimited:
+    maxPodsPerNamespace: 0
+  wide:
+    maxPodsPerWorkload: 4
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for ns, want := range map[string]PodCountLimits{
+		"default":   {MaxPodsPerNamespace: 20, MaxPodsPerWorkload: 2},
+		"unlimited": {MaxPodsPerNamespace: 0, MaxPodsPerWorkload: 2},
+		"wide":      {MaxPodsPerNamespace: 20, MaxPodsPerWorkload: 4},
+	} {
+		if got, _ := pc.limitsOf(ns); got != want {
+			t.Errorf("limits of %s = %+v, want %+v", ns, got, want)
+		}
+	}
+
+	if err := pc.load([]byte(`
+namespaces:
+  negative:
+    maxPodsPerWorkload: -1
+`)); err == nil {
+		t.Errorf("negative override is accepted")
+	}
+}
@@ -1,21 +1,178 @@
 package predicates
 
 import (
+	"context"
+	"fmt"
+
+	"github.com/xkcp0324/custom-scheduler/pkg/observability/tracing"
+	"github.com/xkcp0324/custom-scheduler/pkg/scheduler/reservation"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	schedulerapi "k8s.io/kubernetes/pkg/scheduler/api"
 	schedulerapiv1 "k8s.io/kubernetes/pkg/scheduler/api/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sort"
 )
 
+// The reasons why the predicates filter out a node. They do not vary with the
+// pod, so kube-scheduler can aggregate them and the metrics can use them as labels.
+const (
+	ReasonTerminationNotice  = "node has a termination notice"
+	ReasonInterruptibleShare = "app reached the max share of replicas on interruptible nodes"
+	ReasonNotInTenantPool    = "node is not in the pool of the tenant"
+	ReasonDedicatedPool      = "node is in a dedicated pool"
+	ReasonNamespacePodLimit  = "node reached the pod limit of the namespace"
+	ReasonWorkloadPodLimit   = "node reached the pod limit of the app"
+)
+
 // Predicate is an interface as extender-implemented predicate functions
 type Predicate interface {
 	// Name return the predicate name
 	Name() string
 
-	// Filter function receives a set of nodes and returns a set of candidate nodes.
-	Filter(string, *corev1.Pod, []corev1.Node) ([]corev1.Node, error)
+	// Filter function receives a set of nodes and returns a set of candidate nodes,
+	// with the reason why each of the other nodes was filtered out.
+	Filter(context.Context, string, *corev1.Pod, []corev1.Node) ([]corev1.Node, schedulerapiv1.FailedNodesMap, error)
 
 	// Priority function receives a set of HostPriorityList.
-	Priority(*corev1.Pod, []corev1.Node) (schedulerapiv1.HostPriorityList, error)
+	Priority(context.Context, *corev1.Pod, []corev1.Node) (schedulerapiv1.HostPriorityList, error)
+}
+
+// Weigher is implemented by the predicates whose priority carries a weight,
+// the weight of the other predicates is 1
+type Weigher interface {
+	Weight() int
+}
+
+// Admitter is implemented by the predicates whose limits are checked again when
+// a pod is bound, against the reservations of the other pods being bound
+type Admitter interface {
+	Admit(ctx context.Context, pod *corev1.Pod, nodeName string, reserved []reservation.Reservation) error
+}
+
+// Configurable is implemented by the predicates loading a config file
+type Configurable interface {
+	// ConfigErr returns the error of the last load of the config, nil when the
+	// current config is valid
+	ConfigErr() error
+}
+
+// GetWeight returns the weight of the predicate priority
+func GetWeight(predicate Predicate) int {
+	if w, ok := predicate.(Weigher); ok {
+		return w.Weight()
+	}
+	return 1
+}
+
+// NormalizePriority scales the scores in place into [0, schedulerapi.MaxPriority],
+// the lowest score maps to 0 and the highest to MaxPriority
+func NormalizePriority(result schedulerapiv1.HostPriorityList) {
+	if len(result) == 0 {
+		return
+	}
+
+	min, max := result[0].Score, result[0].Score
+	for _, hp := range result {
+		if hp.Score < min {
+			min = hp.Score
+		}
+		if hp.Score > max {
+			max = hp.Score
+		}
+	}
+
+	for i := range result {
+		if max == min {
+			result[i].Score = 0
+			continue
+		}
+		result[i].Score = (result[i].Score - min) * schedulerapi.MaxPriority / (max - min)
+	}
+}
+
+// getNode returns the node from the candidates, or from the manager cache when the
+// node is not a candidate
+func getNode(ctx context.Context, mgr manager.Manager, nodeName string, candidates map[string]*corev1.Node) (*corev1.Node, error) {
+	if node, ok := candidates[nodeName]; ok {
+		return node, nil
+	}
+
+	node := &corev1.Node{}
+	if err := cacheGet(ctx, mgr, client.ObjectKey{Name: nodeName}, node); err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+// cacheGet reads an object from the manager cache in a span of the request trace
+func cacheGet(ctx context.Context, mgr manager.Manager, key client.ObjectKey, obj runtime.Object) error {
+	ctx, span := tracing.StartSpan(ctx, "cache.Get")
+	defer span.Finish()
+	span.SetAttribute("cache.kind", fmt.Sprintf("%T", obj))
+	span.SetAttribute("cache.key", key.String())
+
+	err := mgr.GetClient().Get(ctx, key, obj)
+	span.SetError(err)
+	return err
+}
+
+// cacheList lists objects from the manager cache in a span of the request trace
+func cacheList(ctx context.Context, mgr manager.Manager, list runtime.Object, opts ...client.ListOption) error {
+	ctx, span := tracing.StartSpan(ctx, "cache.List")
+	defer span.Finish()
+	span.SetAttribute("cache.kind", fmt.Sprintf("%T", list))
+
+	err := mgr.GetClient().List(ctx, list, opts...)
+	span.SetError(err)
+	return err
+}
+
+func nodesByName(nodes []corev1.Node) map[string]*corev1.Node {
+	ret := make(map[string]*corev1.Node, len(nodes))
+	for i := range nodes {
+		ret[nodes[i].Name] = &nodes[i]
+	}
+	return ret
+}
+
+// podRequests returns the resources requested by the pod, which is the larger of
+// the sum of the containers and the largest init container
+func podRequests(pod *corev1.Pod) corev1.ResourceList {
+	requests := corev1.ResourceList{}
+	for _, container := range pod.Spec.Containers {
+		for name, quantity := range container.Resources.Requests {
+			if q, ok := requests[name]; ok {
+				q.Add(quantity)
+				requests[name] = q
+			} else {
+				requests[name] = quantity.DeepCopy()
+			}
+		}
+	}
+
+	for _, container := range pod.Spec.InitContainers {
+		for name, quantity := range container.Resources.Requests {
+			if q, ok := requests[name]; !ok || quantity.Cmp(q) > 0 {
+				requests[name] = quantity.DeepCopy()
+			}
+		}
+	}
+	return requests
+}
+
+// zeroPriority scores every node 0, it is the priority of the predicates which
+// only filter nodes
+func zeroPriority(nodes []corev1.Node) schedulerapiv1.HostPriorityList {
+	result := make(schedulerapiv1.HostPriorityList, 0, len(nodes))
+	for _, node := range nodes {
+		result = append(result, schedulerapiv1.HostPriority{
+			Host:  node.Name,
+			Score: 0,
+		})
+	}
+	return result
 }
 
 func getNodeFromNames(nodes []corev1.Node, nodeNames []string) []corev1.Node {
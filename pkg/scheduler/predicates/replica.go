@@ -0,0 +1,108 @@
+package predicates
+
+import (
+	"context"
+
+	"github.com/xkcp0324/custom-scheduler/pkg/observability/logging"
+	"github.com/xkcp0324/custom-scheduler/pkg/observe"
+	"github.com/xkcp0324/custom-scheduler/pkg/scheduler/reservation"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// ReplicaIndex counts the scheduled replicas of an app on every node. The pods
+// are read from the manager cache, so a lookup never reaches the apiserver.
+// The reservations of the pods being bound are counted with them.
+type ReplicaIndex struct {
+	mgr          manager.Manager
+	reservations reservation.Store
+}
+
+// NewReplicaIndex returns a ReplicaIndex backed by the manager cache, the
+// reservations are ignored when the store is nil
+func NewReplicaIndex(mgr manager.Manager, reservations reservation.Store) *ReplicaIndex {
+	return &ReplicaIndex{
+		mgr:          mgr,
+		reservations: reservations,
+	}
+}
+
+// Pods returns the pods of the app in the namespace
+func (ri *ReplicaIndex) Pods(ctx context.Context, ns string, instanceName string) ([]corev1.Pod, error) {
+	podList := &corev1.PodList{}
+	err := cacheList(ctx, ri.mgr, podList, client.InNamespace(ns),
+		client.MatchingLabels{observe.ObserveMustLabelAppName: instanceName})
+	if err != nil {
+		return nil, err
+	}
+
+	return podList.Items, nil
+}
+
+// ReplicasByNode returns node name => number of replicas of the app bound to that
+// node. The pod with the exclude UID is not counted, so the pod being placed is
+// not counted against itself when it is already scheduled.
+func (ri *ReplicaIndex) ReplicasByNode(ctx context.Context, ns string, instanceName string, exclude types.UID) (map[string]int, error) {
+	pods, err := ri.Pods(ctx, ns, instanceName)
+	if err != nil {
+		return nil, err
+	}
+
+	replicas := make(map[string]int)
+	bound := make(map[string]bool)
+	if exclude != "" {
+		bound[string(exclude)] = true
+	}
+	for _, pod := range pods {
+		nodeName := pod.Spec.NodeName
+		if nodeName == "" || isTerminated(&pod) || (exclude != "" && pod.UID == exclude) {
+			continue
+		}
+		replicas[nodeName]++
+		bound[string(pod.UID)] = true
+	}
+
+	reserved := reservedByNode(listReservations(ctx, ri.reservations), bound, func(r *reservation.Reservation) bool {
+		return r.Namespace == ns && r.App == instanceName
+	})
+	for nodeName, n := range reserved {
+		replicas[nodeName] += n
+	}
+
+	return replicas, nil
+}
+
+// listReservations returns the reservations of the store. The reservations are
+// best effort, none is returned when the store is nil or fails.
+func listReservations(ctx context.Context, store reservation.Store) []reservation.Reservation {
+	if store == nil {
+		return nil
+	}
+
+	reservations, err := store.List(ctx)
+	if err != nil {
+		logging.FromContext(ctx).Error(err, "list reservations err")
+		return nil
+	}
+	return reservations
+}
+
+// reservedByNode returns node name => number of matching reservations of the
+// pods which are not bound in the cache yet
+func reservedByNode(reservations []reservation.Reservation, bound map[string]bool, match func(*reservation.Reservation) bool) map[string]int {
+	ret := make(map[string]int)
+	for i := range reservations {
+		r := &reservations[i]
+		if bound[r.UID] || !match(r) {
+			continue
+		}
+		ret[r.Node]++
+	}
+	return ret
+}
+
+func isTerminated(pod *corev1.Pod) bool {
+	return pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed
+}
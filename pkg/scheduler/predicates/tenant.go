@@ -0,0 +1,226 @@
+package predicates
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/xkcp0324/custom-scheduler/pkg/config"
+	"github.com/xkcp0324/custom-scheduler/pkg/observability/logging"
+	"github.com/xkcp0324/custom-scheduler/pkg/observe"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	schedulerapiv1 "k8s.io/kubernetes/pkg/scheduler/api/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// DefaultTenantPoolLabel is the node label whose value is the pool of the node
+	DefaultTenantPoolLabel = "custom-scheduler/pool"
+)
+
+// TenantArgs are the arguments of the TenantPool predicate
+type TenantArgs struct {
+	// ConfigFile is the path of the TenantConfig, the predicate keeps every node
+	// when it is empty
+	ConfigFile string
+}
+
+// TenantConfig maps the namespaces of the tenants to their dedicated node pools
+type TenantConfig struct {
+	// PoolLabel is the node label whose value is the pool of the node
+	PoolLabel string `json:"poolLabel,omitempty"`
+
+	Tenants []Tenant `json:"tenants"`
+}
+
+// Tenant owns the namespaces listed by name or matched by the selector
+type Tenant struct {
+	Name string `json:"name"`
+
+	// Pool is the value of the pool label of the dedicated nodes
+	Pool string `json:"pool"`
+
+	Namespaces        []string              `json:"namespaces,omitempty"`
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+}
+
+type tenantPolicy struct {
+	poolLabel  string
+	namespaces map[string]string
+	selectors  []tenantSelector
+	pools      map[string]bool
+}
+
+type tenantSelector struct {
+	selector labels.Selector
+	pool     string
+}
+
+type tenantPool struct {
+	lock     sync.RWMutex
+	mgr      manager.Manager
+	policy   *tenantPolicy
+	reloader *config.Reloader
+}
+
+// NewTenantPool returns a Predicate which keeps the pods of a tenant on its
+// dedicated pool and the other pods off every dedicated pool. The config file
+// is reloaded when it changes.
+func NewTenantPool(mgr manager.Manager, args TenantArgs) (Predicate, error) {
+	t := &tenantPool{
+		mgr: mgr,
+	}
+
+	if args.ConfigFile == "" {
+		return t, nil
+	}
+
+	t.reloader = config.NewReloader(args.ConfigFile, config.DefaultReloadInterval, t.load)
+	if err := t.reloader.Load(); err != nil {
+		return nil, err
+	}
+	if err := mgr.Add(t.reloader); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+func (t *tenantPool) Name() string {
+	return "TenantPool"
+}
+
+// ConfigErr returns the error of the last load of the config file
+func (t *tenantPool) ConfigErr() error {
+	if t.reloader == nil {
+		return nil
+	}
+	return t.reloader.Err()
+}
+
+func (t *tenantPool) load(data []byte) error {
+	cfg := &TenantConfig{}
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return err
+	}
+
+	policy := &tenantPolicy{
+		poolLabel:  cfg.PoolLabel,
+		namespaces: make(map[string]string),
+		pools:      make(map[string]bool),
+	}
+	if policy.poolLabel == "" {
+		policy.poolLabel = DefaultTenantPoolLabel
+	}
+
+	for _, tenant := range cfg.Tenants {
+		if tenant.Pool == "" {
+			return fmt.Errorf("tenant %q has no pool", tenant.Name)
+		}
+		policy.pools[tenant.Pool] = true
+
+		for _, ns := range tenant.Namespaces {
+			if pool, ok := policy.namespaces[ns]; ok && pool != tenant.Pool {
+				return fmt.Errorf("namespace %q is mapped to pools %q and %q", ns, pool, tenant.Pool)
+			}
+			policy.namespaces[ns] = tenant.Pool
+		}
+
+		if tenant.NamespaceSelector != nil {
+			selector, err := metav1.LabelSelectorAsSelector(tenant.NamespaceSelector)
+			if err != nil {
+				return fmt.Errorf("tenant %q namespace selector err: %v", tenant.Name, err)
+			}
+			policy.selectors = append(policy.selectors, tenantSelector{selector: selector, pool: tenant.Pool})
+		}
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.policy = policy
+	return nil
+}
+
+func (t *tenantPool) Filter(ctx context.Context, instanceName string, pod *corev1.Pod, nodes []corev1.Node) ([]corev1.Node, schedulerapiv1.FailedNodesMap, error) {
+	t.lock.RLock()
+	policy := t.policy
+	t.lock.RUnlock()
+
+	if policy == nil {
+		return nodes, nil, nil
+	}
+
+	ns := pod.GetNamespace()
+	if isBreakGlass(pod) {
+		logging.FromContext(ctx).Info("pod breaks the tenant pools")
+		return nodes, nil, nil
+	}
+
+	pool, err := t.poolOf(ctx, policy, ns)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var ret []corev1.Node
+	failed := schedulerapiv1.FailedNodesMap{}
+	for _, node := range nodes {
+		nodePool := node.Labels[policy.poolLabel]
+		switch {
+		case pool != "" && nodePool != pool:
+			failed[node.Name] = ReasonNotInTenantPool
+		case pool == "" && policy.pools[nodePool]:
+			failed[node.Name] = ReasonDedicatedPool
+		default:
+			ret = append(ret, node)
+		}
+	}
+
+	logging.FromContext(ctx).V(3).Info("tenant pool filter", "pool", pool, "nodes", GetNodeNames(ret))
+	return ret, failed, nil
+}
+
+// Relaxed implements Relaxer, the break-glass annotation lifts the tenant pools
+func (t *tenantPool) Relaxed(pod *corev1.Pod) string {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	if t.policy == nil || !isBreakGlass(pod) {
+		return ""
+	}
+	return fmt.Sprintf("annotation %s lifts the tenant node pools", observe.ObserveAnnotationBreakGlass)
+}
+
+func isBreakGlass(pod *corev1.Pod) bool {
+	return pod.Annotations[observe.ObserveAnnotationBreakGlass] == "true"
+}
+
+// poolOf returns the dedicated pool of the namespace, empty for the shared pool
+func (t *tenantPool) poolOf(ctx context.Context, policy *tenantPolicy, ns string) (string, error) {
+	if pool, ok := policy.namespaces[ns]; ok {
+		return pool, nil
+	}
+	if len(policy.selectors) == 0 {
+		return "", nil
+	}
+
+	namespace := &corev1.Namespace{}
+	if err := cacheGet(ctx, t.mgr, client.ObjectKey{Name: ns}, namespace); err != nil {
+		logging.FromContext(ctx).Error(err, "get namespace err", "namespace", ns)
+		return "", err
+	}
+
+	for _, s := range policy.selectors {
+		if s.selector.Matches(labels.Set(namespace.Labels)) {
+			return s.pool, nil
+		}
+	}
+	return "", nil
+}
+
+func (t *tenantPool) Priority(ctx context.Context, pod *corev1.Pod, nodes []corev1.Node) (schedulerapiv1.HostPriorityList, error) {
+	return zeroPriority(nodes), nil
+}
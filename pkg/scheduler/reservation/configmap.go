@@ -0,0 +1,181 @@
+package reservation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog"
+)
+
+// configMapStore shares the reservations of every replica in a ConfigMap, pod
+// UID => reservation. The updates carry the resourceVersion they read, so two
+// replicas writing at once conflict and retry instead of losing a reservation.
+// A ConfigMap holds at most 1 MiB, the reservations are capped by
+// MaxReservations to stay under it.
+type configMapStore struct {
+	kubeCli kubernetes.Interface
+	opt     Options
+
+	lock     sync.Mutex
+	snapshot []Reservation
+	readAt   time.Time
+}
+
+func newConfigMapStore(kubeCli kubernetes.Interface, opt Options) *configMapStore {
+	return &configMapStore{
+		kubeCli: kubeCli,
+		opt:     opt,
+	}
+}
+
+func (s *configMapStore) Reserve(_ context.Context, r *Reservation, check Check) error {
+	reservation := *r
+	reservation.Expires = time.Now().Add(s.opt.TTL)
+	data, err := json.Marshal(reservation)
+	if err != nil {
+		return err
+	}
+
+	return s.update(func(entries map[string]string) error {
+		if _, ok := entries[r.UID]; !ok && len(entries) >= s.opt.MaxReservations {
+			return fmt.Errorf("reservations of %s/%s reached the max %d", s.opt.Namespace, s.opt.Name, s.opt.MaxReservations)
+		}
+		if check != nil {
+			reserved := make([]Reservation, 0, len(entries))
+			for uid, data := range entries {
+				if other, err := decode(data); err == nil && uid != r.UID {
+					reserved = append(reserved, *other)
+				}
+			}
+			if err := check(reserved); err != nil {
+				return err
+			}
+		}
+		entries[r.UID] = string(data)
+		return nil
+	})
+}
+
+func (s *configMapStore) Release(_ context.Context, uid string) error {
+	return s.update(func(entries map[string]string) error {
+		delete(entries, uid)
+		return nil
+	})
+}
+
+// List returns the reservations read at most RefreshInterval ago
+func (s *configMapStore) List(_ context.Context) ([]Reservation, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	now := time.Now()
+	if now.Sub(s.readAt) < s.opt.RefreshInterval {
+		return unexpired(s.snapshot, now), nil
+	}
+
+	cm, err := s.kubeCli.CoreV1().ConfigMaps(s.opt.Namespace).Get(s.opt.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		s.setSnapshot(nil, now)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s.setSnapshot(cm.Data, now)
+	return unexpired(s.snapshot, now), nil
+}
+
+// update applies the change to the ConfigMap, creating it when it is missing,
+// and drops the expired reservations. It retries on conflict, the change runs
+// again on the entries read by every attempt and an error of it aborts.
+func (s *configMapStore) update(change func(entries map[string]string) error) error {
+	cms := s.kubeCli.CoreV1().ConfigMaps(s.opt.Namespace)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		cm, err := cms.Get(s.opt.Name, metav1.GetOptions{})
+		exists := err == nil
+		if apierrors.IsNotFound(err) {
+			cm = &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: s.opt.Namespace,
+					Name:      s.opt.Name,
+				},
+			}
+		} else if err != nil {
+			return err
+		}
+
+		entries := make(map[string]string, len(cm.Data)+1)
+		now := time.Now()
+		for uid, data := range cm.Data {
+			r, err := decode(data)
+			if err != nil || r.Expired(now) {
+				continue
+			}
+			entries[uid] = data
+		}
+		if err := change(entries); err != nil {
+			return err
+		}
+		cm.Data = entries
+
+		if !exists {
+			cm, err = cms.Create(cm)
+			if apierrors.IsAlreadyExists(err) {
+				// another replica created it first, read it again
+				return apierrors.NewConflict(corev1.Resource("configmaps"), s.opt.Name, err)
+			}
+		} else {
+			cm, err = cms.Update(cm)
+		}
+		if err != nil {
+			return err
+		}
+
+		s.lock.Lock()
+		s.setSnapshot(cm.Data, now)
+		s.lock.Unlock()
+		return nil
+	})
+}
+
+// setSnapshot decodes the entries of the ConfigMap, the lock must be held
+func (s *configMapStore) setSnapshot(entries map[string]string, now time.Time) {
+	snapshot := make([]Reservation, 0, len(entries))
+	for uid, data := range entries {
+		r, err := decode(data)
+		if err != nil {
+			klog.V(3).Infof("skip reservation %s of %s/%s: %v", uid, s.opt.Namespace, s.opt.Name, err)
+			continue
+		}
+		snapshot = append(snapshot, *r)
+	}
+	s.snapshot = snapshot
+	s.readAt = now
+}
+
+func decode(data string) (*Reservation, error) {
+	r := &Reservation{}
+	if err := json.Unmarshal([]byte(data), r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func unexpired(reservations []Reservation, now time.Time) []Reservation {
+	ret := make([]Reservation, 0, len(reservations))
+	for _, r := range reservations {
+		if !r.Expired(now) {
+			ret = append(ret, r)
+		}
+	}
+	return ret
+}
@@ -0,0 +1,183 @@
+package reservation
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+)
+
+// The backends of a Store
+const (
+	// BackendMemory keeps the reservations of the replica in memory
+	BackendMemory = "memory"
+
+	// BackendConfigMap shares the reservations of every replica in a ConfigMap
+	BackendConfigMap = "configmap"
+)
+
+const (
+	// DefaultTTL is how long a reservation lasts when the binding of its pod is
+	// never observed
+	DefaultTTL = 30 * time.Second
+
+	// DefaultName is the name of the ConfigMap of the configmap backend
+	DefaultName = "custom-scheduler-reservations"
+
+	// DefaultRefreshInterval is how long the configmap backend reuses the
+	// reservations it read
+	DefaultRefreshInterval = 500 * time.Millisecond
+
+	// DefaultMaxReservations caps the reservations of the configmap backend. An
+	// object is at most 1 MiB in etcd and a reservation takes about 250 bytes, so
+	// the cap keeps the ConfigMap well under the limit.
+	DefaultMaxReservations = 2000
+)
+
+// Reservation is an in-flight placement: the pod is being bound to the node but
+// the binding may not be in the informer cache yet
+type Reservation struct {
+	UID       string    `json:"uid"`
+	Namespace string    `json:"namespace"`
+	Pod       string    `json:"pod"`
+	App       string    `json:"app,omitempty"`
+	Node      string    `json:"node"`
+	Expires   time.Time `json:"expires"`
+}
+
+// Expired returns whether the reservation expired at the given time
+func (r *Reservation) Expired(now time.Time) bool {
+	return !now.Before(r.Expires)
+}
+
+// Check validates a reservation against the reservations of the other pods the
+// store holds when it writes it, an error aborts the reservation
+type Check func(reserved []Reservation) error
+
+// Store keeps the reservations, a reservation expires after the TTL of the store
+type Store interface {
+	// Reserve records the placement of the pod, it replaces the reservation of
+	// the same pod. The check runs on the reservations being replaced, so two
+	// replicas reserving at once see each other; it may be nil.
+	Reserve(ctx context.Context, r *Reservation, check Check) error
+
+	// Release drops the reservation of the pod
+	Release(ctx context.Context, uid string) error
+
+	// List returns the reservations which did not expire
+	List(ctx context.Context) ([]Reservation, error)
+}
+
+// Options are options for constructing a Store
+type Options struct {
+	// Backend is either memory or configmap
+	Backend string
+
+	// Namespace and Name locate the ConfigMap of the configmap backend
+	Namespace string
+	Name      string
+
+	// TTL is how long a reservation lasts
+	TTL time.Duration
+
+	// RefreshInterval is how long the configmap backend reuses the reservations
+	// it read before it reads them again
+	RefreshInterval time.Duration
+
+	// MaxReservations caps the reservations of the configmap backend, a
+	// reservation beyond it fails
+	MaxReservations int
+}
+
+// NewStore returns the Store of the backend
+func NewStore(kubeCli kubernetes.Interface, opt *Options) (Store, error) {
+	o := *opt
+	if o.TTL <= 0 {
+		o.TTL = DefaultTTL
+	}
+	if o.Name == "" {
+		o.Name = DefaultName
+	}
+	if o.RefreshInterval <= 0 {
+		o.RefreshInterval = DefaultRefreshInterval
+	}
+	if o.MaxReservations <= 0 {
+		o.MaxReservations = DefaultMaxReservations
+	}
+
+	switch o.Backend {
+	case "", BackendMemory:
+		return newMemoryStore(o.TTL), nil
+	case BackendConfigMap:
+		if o.Namespace == "" {
+			return nil, fmt.Errorf("the configmap backend requires a namespace")
+		}
+		return newConfigMapStore(kubeCli, o), nil
+	default:
+		return nil, fmt.Errorf("unknown reservation backend %q", o.Backend)
+	}
+}
+
+// memoryStore keeps the reservations of the replica, the other replicas do not see them
+type memoryStore struct {
+	lock         sync.RWMutex
+	ttl          time.Duration
+	reservations map[string]Reservation
+}
+
+func newMemoryStore(ttl time.Duration) *memoryStore {
+	return &memoryStore{
+		ttl:          ttl,
+		reservations: make(map[string]Reservation),
+	}
+}
+
+func (s *memoryStore) Reserve(_ context.Context, r *Reservation, check Check) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	now := time.Now()
+	reserved := make([]Reservation, 0, len(s.reservations))
+	for uid, existing := range s.reservations {
+		if existing.Expired(now) {
+			delete(s.reservations, uid)
+			continue
+		}
+		if uid != r.UID {
+			reserved = append(reserved, existing)
+		}
+	}
+	if check != nil {
+		if err := check(reserved); err != nil {
+			return err
+		}
+	}
+
+	reservation := *r
+	reservation.Expires = now.Add(s.ttl)
+	s.reservations[r.UID] = reservation
+	return nil
+}
+
+func (s *memoryStore) Release(_ context.Context, uid string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.reservations, uid)
+	return nil
+}
+
+func (s *memoryStore) List(_ context.Context) ([]Reservation, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	now := time.Now()
+	ret := make([]Reservation, 0, len(s.reservations))
+	for _, r := range s.reservations {
+		if !r.Expired(now) {
+			ret = append(ret, r)
+		}
+	}
+	return ret, nil
+}
@@ -1,14 +1,18 @@
 package scheduler
 
 import (
+	"context"
 	"net/http"
 	"sync"
-	"k8s.io/klog"
+	"time"
 	"github.com/gin-gonic/gin"
+	"github.com/go-logr/logr"
 	"k8s.io/client-go/kubernetes"
 	schedulerapiv1 "k8s.io/kubernetes/pkg/scheduler/api/v1"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"github.com/xkcp0324/custom-scheduler/pkg/router"
+	"github.com/xkcp0324/custom-scheduler/pkg/observability/logging"
+	"github.com/xkcp0324/custom-scheduler/pkg/scheduler/wire"
 )
 
 // ErrorResponse describes responses when an error occurred
@@ -21,21 +25,31 @@ type ErrorResponse struct {
 type Server struct {
 	scheduler Scheduler
 	lock      sync.Mutex
+
+	// dumpSampler samples the requests whose args are logged
+	dumpSampler *logging.Sampler
 }
 
 // StartServer starts a kubernetes scheduler extender http apiserver
-func NewServer(kubeCli kubernetes.Interface, mgr manager.Manager) *Server {
-	s := NewScheduler(kubeCli, mgr)
-	return &Server{scheduler: s}
+func NewServer(kubeCli kubernetes.Interface, mgr manager.Manager, opt *SchedulerOptions) (*Server, error) {
+	s, err := NewScheduler(kubeCli, mgr, opt)
+	if err != nil {
+		return nil, err
+	}
+	return &Server{
+		scheduler:   s,
+		dumpSampler: logging.NewSampler(opt.ArgsDumpEvery),
+	}, nil
 }
 
 func (svr *Server) filterNode(ctx *gin.Context) {
 	svr.lock.Lock()
 	defer svr.lock.Unlock()
 
-	args := &schedulerapiv1.ExtenderArgs{}
-	if err := ctx.BindJSON(args); err != nil {
-		klog.Errorf("filterNode unable to read request body")
+	logger := logging.FromContext(ctx.Request.Context())
+	args, dialect, err := wire.ReadArgs(ctx.Request.Body)
+	if err != nil {
+		logger.Error(err, "filterNode unable to read request body")
 		ctx.JSON(http.StatusBadRequest, ErrorResponse{
 			Code:    http.StatusBadRequest,
 			Message: "unable to read request body",
@@ -44,10 +58,10 @@ func (svr *Server) filterNode(ctx *gin.Context) {
 		return
 	}
 
-	klog.Infof("filterNode args:%#v", args)
-	filterResult, err := svr.scheduler.Filter(args)
+	svr.dumpArgs(logger, "filterNode", args)
+	filterResult, err := svr.scheduler.Filter(ctx.Request.Context(), args)
 	if err != nil {
-		klog.Errorf("unable to filter nodes")
+		logger.Error(err, "unable to filter nodes")
 		ctx.JSON(http.StatusInternalServerError, ErrorResponse{
 			Code:    http.StatusInternalServerError,
 			Message: "unable to filter nodes",
@@ -56,16 +70,35 @@ func (svr *Server) filterNode(ctx *gin.Context) {
 		return
 	}
 
-	ctx.JSON(http.StatusOK, filterResult)
+	ctx.JSON(http.StatusOK, wire.FilterResult(dialect, filterResult))
+}
+
+// FailOpenFilter answers a filter request shed by the router limits with every
+// node, so kube-scheduler keeps scheduling without the extender predicates. It
+// does not take the lock, the request was shed because the extender is busy.
+func (svr *Server) FailOpenFilter(ctx *gin.Context) {
+	args, dialect, err := wire.ReadArgs(ctx.Request.Body)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{
+			Code:    http.StatusBadRequest,
+			Message: "unable to read request body",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	result := svr.scheduler.ShedFilter(ctx.Request.Context(), args, "the filter request was shed by the router limits")
+	ctx.JSON(http.StatusOK, wire.FilterResult(dialect, result))
 }
 
 func (svr *Server) prioritizeNode(ctx *gin.Context) {
 	svr.lock.Lock()
 	defer svr.lock.Unlock()
 
-	args := &schedulerapiv1.ExtenderArgs{}
-	if err := ctx.BindJSON(args); err != nil {
-		klog.Errorf("prioritizeNode unable to read request body")
+	logger := logging.FromContext(ctx.Request.Context())
+	args, dialect, err := wire.ReadArgs(ctx.Request.Body)
+	if err != nil {
+		logger.Error(err, "prioritizeNode unable to read request body")
 		ctx.JSON(http.StatusBadRequest, ErrorResponse{
 			Code:    http.StatusBadRequest,
 			Message: "unable to read request body",
@@ -74,10 +107,10 @@ func (svr *Server) prioritizeNode(ctx *gin.Context) {
 		return
 	}
 
-	klog.Infof("prioritizeNode args:%+v", args)
-	priorityResult, err := svr.scheduler.Priority(args)
+	svr.dumpArgs(logger, "prioritizeNode", args)
+	priorityResult, err := svr.scheduler.Priority(ctx.Request.Context(), args)
 	if err != nil {
-		klog.Errorf("unable to priority nodes")
+		logger.Error(err, "unable to priority nodes")
 		ctx.JSON(http.StatusInternalServerError, ErrorResponse{
 			Code:    http.StatusInternalServerError,
 			Message: "unable to priority nodes",
@@ -87,14 +120,145 @@ func (svr *Server) prioritizeNode(ctx *gin.Context) {
 		return
 	}
 
-	ctx.JSON(http.StatusOK, priorityResult)
+	ctx.JSON(http.StatusOK, wire.PriorityResult(dialect, priorityResult))
+}
+
+// bindNode binds the pod to the node kube-scheduler selected, a failed binding
+// is reported in the result as the extender protocol expects
+func (svr *Server) bindNode(ctx *gin.Context) {
+	logger := logging.FromContext(ctx.Request.Context())
+	args, dialect, err := wire.ReadBindingArgs(ctx.Request.Body)
+	if err != nil {
+		logger.Error(err, "bindNode unable to read request body")
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{
+			Code:    http.StatusBadRequest,
+			Message: "unable to read request body",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	result := &schedulerapiv1.ExtenderBindingResult{}
+	if err := svr.scheduler.Bind(ctx.Request.Context(), args); err != nil {
+		logger.Error(err, "unable to bind pod", "pod", args.PodNamespace+"/"+args.PodName, "node", args.Node)
+		result.Error = err.Error()
+	}
+
+	ctx.JSON(http.StatusOK, wire.BindingResult(dialect, result))
+}
+
+func (svr *Server) explain(ctx *gin.Context) {
+	logger := logging.FromContext(ctx.Request.Context())
+	req := &ExplainRequest{}
+	if err := ctx.BindJSON(req); err != nil {
+		logger.Error(err, "explain unable to read request body")
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{
+			Code:    http.StatusBadRequest,
+			Message: "unable to read request body",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	// the predicates share their caches with the filter and prioritize requests
+	svr.lock.Lock()
+	explanation, err := svr.scheduler.Explain(ctx.Request.Context(), req)
+	svr.lock.Unlock()
+	if err != nil {
+		logger.Error(err, "unable to explain pod")
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{
+			Code:    http.StatusBadRequest,
+			Message: "unable to explain pod",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, explanation)
+}
+
+func (svr *Server) decisions(ctx *gin.Context) {
+	q := &DecisionQuery{
+		Namespace: ctx.Query("namespace"),
+		Pod:       ctx.Query("pod"),
+	}
+
+	if since := ctx.Query("since"); since != "" {
+		// since is either a RFC3339 time or a duration before now
+		if t, err := time.Parse(time.RFC3339, since); err == nil {
+			q.Since = t
+		} else if d, err := time.ParseDuration(since); err == nil {
+			q.Since = time.Now().Add(-d)
+		} else {
+			ctx.JSON(http.StatusBadRequest, ErrorResponse{
+				Code:    http.StatusBadRequest,
+				Message: "since is neither a RFC3339 time nor a duration",
+				Error:   err.Error(),
+			})
+			return
+		}
+	}
+
+	ctx.JSON(http.StatusOK, svr.scheduler.Decisions(q))
+}
+
+// Scheduler returns the scheduler of the server for the in-process callers, its
+// filter and prioritize are serialized with the extender requests
+func (svr *Server) Scheduler() Scheduler {
+	return &lockedScheduler{Scheduler: svr.scheduler, lock: &svr.lock}
+}
+
+type lockedScheduler struct {
+	Scheduler
+	lock *sync.Mutex
+}
+
+func (s *lockedScheduler) Filter(ctx context.Context, args *schedulerapiv1.ExtenderArgs) (*schedulerapiv1.ExtenderFilterResult, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.Scheduler.Filter(ctx, args)
+}
+
+func (s *lockedScheduler) Priority(ctx context.Context, args *schedulerapiv1.ExtenderArgs) (schedulerapiv1.HostPriorityList, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.Scheduler.Priority(ctx, args)
+}
+
+// ConfigErr returns the error of the last load of a predicate config
+func (svr *Server) ConfigErr() error {
+	return svr.scheduler.ConfigErr()
+}
+
+// Latency returns the latency quantile of the extender calls of the last window
+func (svr *Server) Latency(quantile float64, window time.Duration) (time.Duration, int) {
+	return svr.scheduler.Latency(quantile, window)
+}
+
+// dumpArgs logs the whole args of a sample of the requests, they hold every node
+// and are too large to be logged every time
+func (svr *Server) dumpArgs(logger logr.Logger, verb string, args *schedulerapiv1.ExtenderArgs) {
+	if !logger.V(4).Enabled() || !svr.dumpSampler.Allow() {
+		return
+	}
+	logger.V(4).Info("extender args", "verb", verb, "args", args)
 }
 
 func (svr *Server) Routes() []*router.Route {
 	schedulerRoute := []*router.Route{
-		{"POST", "/scheduler/filter", svr.filterNode, ""},
-		{"POST", "/scheduler/prioritize", svr.prioritizeNode, ""},
+		{Method: "POST", Path: URLPath + "/" + FilterVerb, Handler: svr.filterNode},
+		{Method: "POST", Path: URLPath + "/" + PrioritizeVerb, Handler: svr.prioritizeNode},
+		{Method: "POST", Path: URLPath + "/" + BindVerb, Handler: svr.bindNode},
 	}
 
 	return schedulerRoute
 }
+
+// InspectRoutes are the routes explaining the decisions, they expose the pods and
+// the nodes and are not meant for kube-scheduler
+func (svr *Server) InspectRoutes() []*router.Route {
+	return []*router.Route{
+		{Method: "POST", Path: URLPath + "/explain", Handler: svr.explain},
+		{Method: "GET", Path: URLPath + "/decisions", Handler: svr.decisions},
+	}
+}
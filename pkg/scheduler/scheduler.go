@@ -1,12 +1,25 @@
 package scheduler
 
 import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xkcp0324/custom-scheduler/pkg/observability/logging"
+	"github.com/xkcp0324/custom-scheduler/pkg/observability/tracing"
+	"github.com/xkcp0324/custom-scheduler/pkg/scheduler/metrics"
 	"github.com/xkcp0324/custom-scheduler/pkg/scheduler/predicates"
+	"github.com/xkcp0324/custom-scheduler/pkg/scheduler/reservation"
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
 
-	"k8s.io/klog"
 	schedulerapiv1 "k8s.io/kubernetes/pkg/scheduler/api/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"github.com/xkcp0324/custom-scheduler/pkg/observe"
 )
@@ -17,26 +30,95 @@ import (
 type Scheduler interface {
 	// Filter based on extender-implemented predicate functions. The filtered list is
 	// expected to be a subset of the supplied list.
-	Filter(*schedulerapiv1.ExtenderArgs) (*schedulerapiv1.ExtenderFilterResult, error)
+	Filter(context.Context, *schedulerapiv1.ExtenderArgs) (*schedulerapiv1.ExtenderFilterResult, error)
 
 	// Prioritize based on extender-implemented priority functions. The returned scores & weight
 	// are used to compute the weighted score for an extender. The weighted scores are added to
 	// the scores computed  by kubernetes scheduler. The total scores are used to do the host selection.
-	Priority(*schedulerapiv1.ExtenderArgs) (schedulerapiv1.HostPriorityList, error)
+	Priority(context.Context, *schedulerapiv1.ExtenderArgs) (schedulerapiv1.HostPriorityList, error)
+
+	// Explain runs every predicate of the pod profile on the nodes in the cache and
+	// traces the decisions, it has no side effect.
+	Explain(context.Context, *ExplainRequest) (*Explanation, error)
+
+	// Decisions returns the recorded filter and prioritize calls matching the query.
+	Decisions(*DecisionQuery) []Decision
+
+	// ConfigErr returns the error of the last load of a predicate config, nil when
+	// every config is valid.
+	ConfigErr() error
+
+	// Latency returns the quantile of the latency of the filter and prioritize
+	// calls of the last window, with the number of calls.
+	Latency(quantile float64, window time.Duration) (time.Duration, int)
+
+	// ShedFilter answers a filter request shed by the router limits with every
+	// node, and records it like a predicate failed open.
+	ShedFilter(ctx context.Context, args *schedulerapiv1.ExtenderArgs, reason string) *schedulerapiv1.ExtenderFilterResult
+
+	// Bind reserves the node for the pod so every replica counts the pod on it,
+	// then binds the pod.
+	Bind(context.Context, *schedulerapiv1.ExtenderBindingArgs) error
 }
 
+// SchedulerOptions are options for constructing a Scheduler
+type SchedulerOptions struct {
+	CoLocation predicates.CoLocationArgs
+	Cost       predicates.CostArgs
+
+	ImageLocality predicates.ImageLocalityArgs
+	Tenant        predicates.TenantArgs
+	PodCount      predicates.PodCountArgs
+
+	// HistorySize is the number of decisions kept in memory, HistoryFile
+	// persists them across restarts when it is set
+	HistorySize int
+	HistoryFile string
+
+	// EventInterval is the min interval between two events of a pod with the same reason
+	EventInterval time.Duration
+
+	// FailOpen skips a failing predicate instead of failing the filter
+	FailOpen bool
+
+	// ArgsDumpEvery logs the args of one of every ArgsDumpEvery requests at V(4)
+	ArgsDumpEvery int
+
+	// Reservation is the store of the pods being bound through the bind verb
+	Reservation reservation.Options
+}
+
+const (
+	// DefaultProfile is the profile of the predicates applied to every pod
+	DefaultProfile = "ha"
+
+	// shedPredicate names the router limits in the fail-open records of a shed
+	// filter request
+	shedPredicate = "shed"
+)
+
 type scheduler struct {
-	// component => predicates
+	kubeCli  kubernetes.Interface
+	mgr      manager.Manager
+	history  *History
+	recorder record.EventRecorder
+
+	// reservations are the in-flight placements of the bind verb
+	reservations reservation.Store
+
+	// failOpen keeps the nodes of a failing predicate instead of failing the filter
+	failOpen bool
+
+	// profile => predicates
 	predicates map[string][]predicates.Predicate
 }
 
 // NewScheduler returns a Scheduler
-func NewScheduler(kubeCli kubernetes.Interface, mgr manager.Manager) Scheduler {
+func NewScheduler(kubeCli kubernetes.Interface, mgr manager.Manager, opt *SchedulerOptions) (Scheduler, error) {
 	cacher := mgr.GetCache()
 	_, err := cacher.GetInformerForKind(corev1.SchemeGroupVersion.WithKind("Pod"))
 	if err != nil {
-		klog.Errorf("cacher get informer err:%+v", err)
-		return nil
+		return nil, fmt.Errorf("get pod informer: %v", err)
 	}
 
 	// priorityClass, err := cacher.GetInformerForKind(corev1.SchemeGroupVersion.WithKind("PriorityClass"))
@@ -48,84 +130,558 @@ func NewScheduler(kubeCli kubernetes.Interface, mgr manager.Manager) Scheduler {
 	// kubeCli.SchedulingV1beta1().PriorityClasses().List()
 
 	// podLister := corelisters.NewPodLister(podInformer.GetIndexer())
-	predicatesByComponent := map[string][]predicates.Predicate{
-		"ha": {
-			predicates.NewHA(kubeCli, mgr),
+	reservations, err := reservation.NewStore(kubeCli, &opt.Reservation)
+	if err != nil {
+		return nil, fmt.Errorf("new reservation store: %v", err)
+	}
+
+	recorder, err := predicates.NewRateLimitedRecorder(mgr, mgr.GetEventRecorderFor("custom-scheduler"), opt.EventInterval)
+	if err != nil {
+		return nil, fmt.Errorf("new event recorder: %v", err)
+	}
+	index := predicates.NewReplicaIndex(mgr, reservations)
+	cost, err := predicates.NewCost(mgr, index, opt.Cost)
+	if err != nil {
+		return nil, fmt.Errorf("new cost predicate: %v", err)
+	}
+
+	tenantPool, err := predicates.NewTenantPool(mgr, opt.Tenant)
+	if err != nil {
+		return nil, fmt.Errorf("new tenant pool predicate: %v", err)
+	}
+
+	podCount, err := predicates.NewPodCount(mgr, opt.PodCount, reservations)
+	if err != nil {
+		return nil, fmt.Errorf("new pod count predicate: %v", err)
+	}
+
+	history, err := NewHistory(opt.HistorySize, opt.HistoryFile)
+	if err != nil {
+		return nil, fmt.Errorf("new decision history: %v", err)
+	}
+
+	predicatesByProfile := map[string][]predicates.Predicate{
+		DefaultProfile: {
+			tenantPool,
+			podCount,
+			predicates.NewHA(kubeCli, mgr, index, recorder),
+			predicates.NewCoLocation(mgr, index, opt.CoLocation),
+			cost,
+			predicates.NewImageLocality(mgr, opt.ImageLocality),
 		},
 	}
 
+	registerCacheMetrics(mgr, reservations)
+
 	return &scheduler{
-		predicates: predicatesByComponent,
-	}
+		kubeCli:      kubeCli,
+		mgr:          mgr,
+		history:      history,
+		recorder:     recorder,
+		reservations: reservations,
+		failOpen:     opt.FailOpen,
+		predicates:   predicatesByProfile,
+	}, nil
+}
+
+// registerCacheMetrics exposes the number of pods and nodes in the manager cache,
+// and of the pods assumed on a node by a reservation
+func registerCacheMetrics(mgr manager.Manager, reservations reservation.Store) {
+	metrics.RegisterCacheSize("pods", func() float64 {
+		podList := &corev1.PodList{}
+		if err := mgr.GetClient().List(context.Background(), podList); err != nil {
+			return 0
+		}
+		return float64(len(podList.Items))
+	})
+	metrics.RegisterCacheSize("nodes", func() float64 {
+		nodeList := &corev1.NodeList{}
+		if err := mgr.GetClient().List(context.Background(), nodeList); err != nil {
+			return 0
+		}
+		return float64(len(nodeList.Items))
+	})
+	metrics.RegisterCacheSize("assumed", func() float64 {
+		list, err := reservations.List(context.Background())
+		if err != nil {
+			return 0
+		}
+		return float64(len(list))
+	})
 }
 
 // Filter selects a set of nodes from *schedulerapiv1.ExtenderArgs.Nodes when this is a pd or tikv pod
 // otherwise, returns the original nodes.
-func (s *scheduler) Filter(args *schedulerapiv1.ExtenderArgs) (*schedulerapiv1.ExtenderFilterResult, error) {
-	pod := args.Pod
-	ns := pod.GetNamespace()
-	podName := pod.GetName()
-	kubeNodes := args.Nodes.Items
-
-	var instanceName string
-	var exist bool
-	if instanceName, exist = pod.Labels[observe.ObserveMustLabelAppName]; !exist {
-		klog.Warningf("can't find instanceName in pod labels: %s/%s", ns, podName)
-		return &schedulerapiv1.ExtenderFilterResult{
-			Nodes: args.Nodes,
-		}, nil
+func (s *scheduler) Filter(ctx context.Context, args *schedulerapiv1.ExtenderArgs) (*schedulerapiv1.ExtenderFilterResult, error) {
+	start := time.Now()
+	decision := newDecision("filter", args)
+	result, err := s.filter(ctx, args, decision)
+
+	decision.LatencyMs = float64(time.Since(start)) / float64(time.Millisecond)
+	if err != nil {
+		decision.Error = err.Error()
+	} else {
+		decision.Nodes = predicates.GetNodeNames(result.Nodes.Items)
+		decision.FailedNodes = result.FailedNodes
 	}
+	s.history.Add(*decision)
+
+	return result, err
+}
 
-	predicatesByComponent, ok := s.predicates["ha"]
+// ShedFilter keeps every node of a filter request the router limits shed, the
+// pod gets the fail-open event and the history the decision like a predicate
+// which failed open.
+func (s *scheduler) ShedFilter(ctx context.Context, args *schedulerapiv1.ExtenderArgs, reason string) *schedulerapiv1.ExtenderFilterResult {
+	decision := newDecision("filter", args)
+	decision.Profile = ProfileOf(args.Pod)
+	decision.FailOpen = map[string]string{shedPredicate: reason}
+	decision.Shed = true
+	decision.Nodes = predicates.GetNodeNames(args.Nodes.Items)
+	s.history.Add(*decision)
+
+	s.recorder.Eventf(args.Pod, corev1.EventTypeWarning, predicates.EventReasonFailOpen,
+		"predicate %s failed, its nodes are kept: %s", shedPredicate, reason)
+	logging.FromContext(ctx).Info("filter request shed, every node is kept", "reason", reason)
+
+	return &schedulerapiv1.ExtenderFilterResult{
+		Nodes:       args.Nodes,
+		FailedNodes: schedulerapiv1.FailedNodesMap{},
+	}
+}
+
+func (s *scheduler) filter(ctx context.Context, args *schedulerapiv1.ExtenderArgs, decision *Decision) (*schedulerapiv1.ExtenderFilterResult, error) {
+	pod := args.Pod
+
+	profile, predicatesByProfile, ok := s.profileOf(pod)
+	decision.Profile = profile
+	ctx = withPod(ctx, pod, profile)
 	if !ok {
 		return &schedulerapiv1.ExtenderFilterResult{
 			Nodes: args.Nodes,
 		}, nil
 	}
 
-	klog.Infof("scheduling pod: %s/%s", ns, podName)
-	var err error
-	for _, predicate := range predicatesByComponent {
-		klog.Infof("entering predicate: %s, nodes: %v", predicate.Name(), predicates.GetNodeNames(kubeNodes))
-		kubeNodes, err = predicate.Filter(instanceName, pod, kubeNodes)
-		if err != nil {
-			return nil, err
-		}
-		klog.Infof("leaving predicate: %s, nodes: %v", predicate.Name(), predicates.GetNodeNames(kubeNodes))
+	logging.FromContext(ctx).Info("filtering nodes", "nodes", len(args.Nodes.Items))
+	trace, err := s.runFilters(ctx, predicatesByProfile, pod, args.Nodes.Items)
+	observeFilters(profile, trace)
+	if err != nil {
+		return nil, err
+	}
+	if len(trace.failOpen) > 0 {
+		decision.FailOpen = trace.failOpen
+	}
+	s.recordFilterEvents(predicatesByProfile, pod, len(args.Nodes.Items), trace)
+
+	failedNodes := schedulerapiv1.FailedNodesMap{}
+	for nodeName, r := range trace.rejections {
+		failedNodes[nodeName] = fmt.Sprintf("%s: %s", r.Predicate, r.Reason)
 	}
 
 	result := &schedulerapiv1.ExtenderFilterResult{
-		Nodes: &corev1.NodeList{Items: kubeNodes},
+		Nodes:       &corev1.NodeList{Items: trace.nodes},
+		FailedNodes: failedNodes,
 	}
 
 	return result, nil
 }
 
-// We don't pass `prioritizeVerb` to kubernetes scheduler extender's config file, this method will not be called.
-func (s *scheduler) Priority(args *schedulerapiv1.ExtenderArgs) (schedulerapiv1.HostPriorityList, error) {
-	result := schedulerapiv1.HostPriorityList{}
+// Priority averages the normalized scores of every predicate of the profile by
+// their weights, a predicate which fails to score the nodes is skipped.
+func (s *scheduler) Priority(ctx context.Context, args *schedulerapiv1.ExtenderArgs) (schedulerapiv1.HostPriorityList, error) {
+	if args.Nodes == nil {
+		return schedulerapiv1.HostPriorityList{}, nil
+	}
+
+	start := time.Now()
+	decision := newDecision("prioritize", args)
+
+	profile, predicatesByProfile, _ := s.profileOf(args.Pod)
+	ctx = withPod(ctx, args.Pod, profile)
+	result, scores := s.runPriorities(ctx, predicatesByProfile, args.Pod, args.Nodes.Items)
+	for _, ps := range scores {
+		if ps.Error != "" {
+			metrics.ObserveError(ps.Predicate, profile, metrics.PhaseScore)
+			continue
+		}
+		metrics.ObserveScore(ps.Predicate, profile, ps.latency, ps.Normalized)
+	}
+
+	decision.Profile = profile
+	decision.Nodes = predicates.GetNodeNames(args.Nodes.Items)
+	decision.Scores = scores
+	decision.Totals = make(map[string]int, len(result))
+	for _, hp := range result {
+		decision.Totals[hp.Host] = hp.Score
+	}
+	decision.LatencyMs = float64(time.Since(start)) / float64(time.Millisecond)
+	s.history.Add(*decision)
+
+	return result, nil
+}
+
+// Decisions returns the recorded decisions matching the query
+func (s *scheduler) Decisions(q *DecisionQuery) []Decision {
+	return s.history.Query(q)
+}
+
+// Bind reserves the node for the pod before it binds it, the reservation is
+// released when the binding fails. The limits of the predicates are checked
+// again when the reservation is written, the bind fails when another pod took
+// the node since the filter.
+func (s *scheduler) Bind(ctx context.Context, args *schedulerapiv1.ExtenderBindingArgs) error {
+	r := &reservation.Reservation{
+		UID:       string(args.PodUID),
+		Namespace: args.PodNamespace,
+		Pod:       args.PodName,
+		Node:      args.Node,
+	}
+	var check reservation.Check
+	pod := &corev1.Pod{}
+	if err := s.mgr.GetClient().Get(ctx, client.ObjectKey{Namespace: args.PodNamespace, Name: args.PodName}, pod); err == nil {
+		ctx = withPod(ctx, pod, ProfileOf(pod))
+		r.App = pod.Labels[observe.ObserveMustLabelAppName]
+		check = s.admit(ctx, pod, args.Node)
+	} else {
+		// the pod is not in the cache yet, the span and the logs still name it
+		pod = &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: args.PodNamespace, Name: args.PodName, UID: args.PodUID}}
+		ctx = withPod(ctx, pod, ProfileOf(pod))
+	}
+
+	logger := logging.FromContext(ctx).WithValues("node", args.Node)
+	var rejected error
+	err := s.reservations.Reserve(ctx, r, func(reserved []reservation.Reservation) error {
+		if check != nil {
+			rejected = check(reserved)
+		}
+		return rejected
+	})
+	if rejected != nil {
+		// another replica took the last slot of the node since the filter, the
+		// pod goes back to kube-scheduler
+		logger.Info("node rejected at bind", "reason", rejected.Error())
+		return rejected
+	}
+	if err != nil {
+		// the pod is still bound, only the other replicas may not count it until
+		// their cache sees the binding
+		logger.Error(err, "reserve node err")
+	}
+
+	err = s.kubeCli.CoreV1().Pods(args.PodNamespace).Bind(&corev1.Binding{
+		ObjectMeta: metav1.ObjectMeta{Namespace: args.PodNamespace, Name: args.PodName, UID: args.PodUID},
+		Target:     corev1.ObjectReference{Kind: "Node", Name: args.Node},
+	})
+	if err != nil {
+		if releaseErr := s.reservations.Release(ctx, r.UID); releaseErr != nil {
+			logger.Error(releaseErr, "release reservation err")
+		}
+		return err
+	}
+
+	logger.V(3).Info("bound pod")
+	return nil
+}
+
+// admit returns the check of the limits of the predicates of the pod profile
+// which are Admitters
+func (s *scheduler) admit(ctx context.Context, pod *corev1.Pod, nodeName string) reservation.Check {
+	_, predicatesByProfile, _ := s.profileOf(pod)
+	var admitters []predicates.Admitter
+	for _, predicate := range predicatesByProfile {
+		if admitter, ok := predicate.(predicates.Admitter); ok {
+			admitters = append(admitters, admitter)
+		}
+	}
+
+	return func(reserved []reservation.Reservation) error {
+		for _, admitter := range admitters {
+			if err := admitter.Admit(ctx, pod, nodeName, reserved); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
 
-	var score int
-	klog.Infof("Priority args:%+v", args)
+// ConfigErr returns the first config error of the predicates
+func (s *scheduler) ConfigErr() error {
+	for _, preds := range s.predicates {
+		for _, pred := range preds {
+			c, ok := pred.(predicates.Configurable)
+			if !ok {
+				continue
+			}
+			if err := c.ConfigErr(); err != nil {
+				return fmt.Errorf("%s: %v", pred.Name(), err)
+			}
+		}
+	}
+	return nil
+}
+
+// Latency returns the latency quantile of the decisions of the last window, the
+// shed requests are not counted
+func (s *scheduler) Latency(quantile float64, window time.Duration) (time.Duration, int) {
+	return s.history.LatencyQuantile(quantile, time.Now().Add(-window))
+}
+
+func newDecision(verb string, args *schedulerapiv1.ExtenderArgs) *Decision {
+	d := &Decision{
+		Time: time.Now(),
+		Verb: verb,
+	}
+	if args.Pod != nil {
+		d.Namespace = args.Pod.GetNamespace()
+		d.Pod = args.Pod.GetName()
+		d.UID = string(args.Pod.GetUID())
+	}
 	if args.Nodes != nil {
-		predicatesByComponent, ok := s.predicates["ha"]
-		if ok {
-			for _, predicate := range predicatesByComponent {
-				ret, err := predicate.Priority(args.Pod, args.Nodes.Items)
-				if err == nil {
-					return ret, nil
-				}
+		d.InputNodes = len(args.Nodes.Items)
+	}
+	return d
+}
+
+// withPod sets the pod and its profile on the span of the request and returns a
+// context whose logger carries them
+func withPod(ctx context.Context, pod *corev1.Pod, profile string) context.Context {
+	span := tracing.SpanFromContext(ctx)
+	span.SetAttribute("pod.uid", string(pod.GetUID()))
+	span.SetAttribute("pod.name", pod.GetNamespace()+"/"+pod.GetName())
+	span.SetAttribute("scheduler.profile", profile)
+
+	logger := logging.FromContext(ctx).WithValues(
+		"pod", pod.GetNamespace()+"/"+pod.GetName(), "profile", profile)
+	return logging.IntoContext(ctx, logger)
+}
+
+// ProfileOf returns the profile of the predicates applied to the pod
+func ProfileOf(pod *corev1.Pod) string {
+	return DefaultProfile
+}
+
+// profileOf returns the profile of the pod and its predicates
+func (s *scheduler) profileOf(pod *corev1.Pod) (string, []predicates.Predicate, bool) {
+	profile := ProfileOf(pod)
+	predicatesByProfile, ok := s.predicates[profile]
+	return profile, predicatesByProfile, ok
+}
+
+// Rejection records the predicate which filtered out a node and why
+type Rejection struct {
+	Predicate string `json:"predicate"`
+	Reason    string `json:"reason"`
+}
+
+// filterTrace is the outcome of the filters
+type filterTrace struct {
+	nodes      []corev1.Node
+	rejections map[string]Rejection
+
+	// failOpen is predicate => error of the predicates skipped in fail-open mode
+	failOpen map[string]string
+
+	steps []filterStep
+}
+
+// filterStep is the run of a single predicate filter
+type filterStep struct {
+	predicate string
+	in, out   int
+	latency   time.Duration
+	err       error
+}
+
+// runFilters runs the predicates one after another on the nodes left by the
+// previous one, it returns the remaining nodes and the rejected ones. It has no
+// side effect, the callers report the trace.
+func (s *scheduler) runFilters(ctx context.Context, predicatesByProfile []predicates.Predicate, pod *corev1.Pod, nodes []corev1.Node) (*filterTrace, error) {
+	// the predicates which spread the replicas of an app skip the pod without
+	// instanceName, the others still apply to it
+	logger := logging.FromContext(ctx)
+	instanceName, exist := pod.Labels[observe.ObserveMustLabelAppName]
+	if !exist {
+		logger.Info("can't find instanceName in pod labels", "label", observe.ObserveMustLabelAppName)
+	}
+
+	trace := &filterTrace{
+		rejections: make(map[string]Rejection),
+		failOpen:   make(map[string]string),
+	}
+	for _, predicate := range predicatesByProfile {
+		logger.V(3).Info("entering predicate", "predicate", predicate.Name(), "nodes", predicates.GetNodeNames(nodes))
+		spanCtx, span := tracing.StartSpan(ctx, "filter "+predicate.Name())
+		start := time.Now()
+		remaining, failed, err := predicate.Filter(spanCtx, instanceName, pod, nodes)
+		step := filterStep{
+			predicate: predicate.Name(),
+			in:        len(nodes),
+			out:       len(remaining),
+			latency:   time.Since(start),
+			err:       err,
+		}
+		if err != nil {
+			step.out = len(nodes)
+		}
+		trace.steps = append(trace.steps, step)
+		span.SetAttribute("nodes.in", strconv.Itoa(step.in))
+		span.SetAttribute("nodes.out", strconv.Itoa(step.out))
+		span.SetError(err)
+		span.Finish()
+
+		if err != nil {
+			if !s.failOpen {
+				return trace, fmt.Errorf("predicate %s: %v", predicate.Name(), err)
 			}
+			logger.Error(err, "predicate failed open", "predicate", predicate.Name())
+			trace.failOpen[predicate.Name()] = err.Error()
+			continue
 		}
-		for _, node := range args.Nodes.Items {
-			result = append(result, schedulerapiv1.HostPriority{
-				Host:  node.Name,
-				Score: score,
-			})
+		nodes = remaining
+		for nodeName, reason := range failed {
+			trace.rejections[nodeName] = Rejection{Predicate: predicate.Name(), Reason: reason}
 		}
+		logger.V(3).Info("leaving predicate", "predicate", predicate.Name(), "nodes", predicates.GetNodeNames(nodes))
 	}
 
-	return result, nil
+	trace.nodes = nodes
+	return trace, nil
+}
+
+// observeFilters reports the filter steps and rejections to the metrics
+func observeFilters(profile string, trace *filterTrace) {
+	for _, step := range trace.steps {
+		if step.err != nil {
+			metrics.ObserveError(step.predicate, profile, metrics.PhaseFilter)
+		}
+		metrics.ObserveFilter(step.predicate, profile, step.latency, step.in, step.out)
+	}
+	for _, r := range trace.rejections {
+		metrics.ObserveRejection(r.Predicate, profile, r.Reason)
+	}
+}
+
+// recordFilterEvents reports to the pod the failed open predicates, the relaxed
+// constraints and the rejection of all or most nodes
+func (s *scheduler) recordFilterEvents(predicatesByProfile []predicates.Predicate, pod *corev1.Pod, inputNodes int, trace *filterTrace) {
+	for name, err := range trace.failOpen {
+		s.recorder.Eventf(pod, corev1.EventTypeWarning, predicates.EventReasonFailOpen,
+			"predicate %s failed, its nodes are kept: %s", name, err)
+	}
+
+	for _, predicate := range predicatesByProfile {
+		if relaxer, ok := predicate.(predicates.Relaxer); ok {
+			if reason := relaxer.Relaxed(pod); reason != "" {
+				s.recorder.Eventf(pod, corev1.EventTypeWarning, predicates.EventReasonConstraintRelaxed,
+					"predicate %s is relaxed: %s", predicate.Name(), reason)
+			}
+		}
+	}
+
+	rejected := len(trace.rejections)
+	switch {
+	case rejected == 0:
+	case len(trace.nodes) == 0:
+		s.recorder.Eventf(pod, corev1.EventTypeWarning, predicates.EventReasonNodesRejected,
+			"all %d nodes are rejected: %s", inputNodes, summarizeRejections(trace.rejections))
+	case 2*rejected > inputNodes:
+		s.recorder.Eventf(pod, corev1.EventTypeNormal, predicates.EventReasonNodesRejected,
+			"%d of %d nodes are rejected: %s", rejected, inputNodes, summarizeRejections(trace.rejections))
+	}
+}
+
+// summarizeRejections counts the nodes by rejection, like "2 TenantPool: reason, 1 Cost: reason"
+func summarizeRejections(rejections map[string]Rejection) string {
+	counts := make(map[string]int)
+	for _, r := range rejections {
+		counts[fmt.Sprintf("%s: %s", r.Predicate, r.Reason)]++
+	}
+
+	reasons := make([]string, 0, len(counts))
+	for reason := range counts {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+
+	items := make([]string, 0, len(reasons))
+	for _, reason := range reasons {
+		items = append(items, fmt.Sprintf("%d %s", counts[reason], reason))
+	}
+	return strings.Join(items, ", ")
+}
+
+// PredicateScores records the scores of the nodes given by a predicate priority
+type PredicateScores struct {
+	Predicate  string         `json:"predicate"`
+	Weight     int            `json:"weight"`
+	Raw        map[string]int `json:"raw,omitempty"`
+	Normalized map[string]int `json:"normalized,omitempty"`
+	LatencyMs  float64        `json:"latencyMs"`
+	Error      string         `json:"error,omitempty"`
+
+	latency time.Duration
+}
+
+// runPriorities returns the weighted mean of the normalized scores of the
+// predicates which succeeded, and the scores of every predicate
+func (s *scheduler) runPriorities(ctx context.Context, predicatesByProfile []predicates.Predicate, pod *corev1.Pod, nodes []corev1.Node) (schedulerapiv1.HostPriorityList, []PredicateScores) {
+	result := schedulerapiv1.HostPriorityList{}
+	for _, node := range nodes {
+		result = append(result, schedulerapiv1.HostPriority{
+			Host:  node.Name,
+			Score: 0,
+		})
+	}
+
+	var traces []PredicateScores
+	totalWeight := 0
+	for _, predicate := range predicatesByProfile {
+		trace := PredicateScores{
+			Predicate: predicate.Name(),
+			Weight:    predicates.GetWeight(predicate),
+		}
+
+		spanCtx, span := tracing.StartSpan(ctx, "score "+predicate.Name())
+		start := time.Now()
+		ret, err := predicate.Priority(spanCtx, pod, nodes)
+		trace.latency = time.Since(start)
+		span.SetAttribute("nodes", strconv.Itoa(len(nodes)))
+		span.SetError(err)
+		span.Finish()
+		trace.LatencyMs = float64(trace.latency) / float64(time.Millisecond)
+		if err != nil {
+			logging.FromContext(ctx).Error(err, "priority failed", "predicate", predicate.Name())
+			trace.Error = err.Error()
+			traces = append(traces, trace)
+			continue
+		}
+
+		trace.Raw = make(map[string]int, len(ret))
+		for _, hp := range ret {
+			trace.Raw[hp.Host] = hp.Score
+		}
+
+		predicates.NormalizePriority(ret)
+		trace.Normalized = make(map[string]int, len(ret))
+		for _, hp := range ret {
+			trace.Normalized[hp.Host] = hp.Score
+		}
+
+		for i := range result {
+			result[i].Score += trace.Normalized[result[i].Host] * trace.Weight
+		}
+		totalWeight += trace.Weight
+		traces = append(traces, trace)
+	}
+
+	// the weighted mean keeps the scores in [0, MaxPriority], kube-scheduler
+	// applies the extender weight on top of them
+	if totalWeight > 0 {
+		for i := range result {
+			result[i].Score /= totalWeight
+		}
+	}
+	return result, traces
 }
 
 var _ Scheduler = &scheduler{}
@@ -0,0 +1,168 @@
+package wire
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	corev1 "k8s.io/api/core/v1"
+	schedulerapi "k8s.io/kubernetes/pkg/scheduler/api"
+	schedulerapiv1 "k8s.io/kubernetes/pkg/scheduler/api/v1"
+)
+
+// Dialect is the shape of the extender messages of a kube-scheduler version.
+// The scheduler works on the k8s.io/kubernetes/pkg/scheduler/api/v1 types, the
+// messages are decoded into them and the replies encoded in the dialect of the
+// caller.
+type Dialect string
+
+const (
+	// DialectLegacy is the k8s.io/kubernetes/pkg/scheduler/api/v1 shape of
+	// Kubernetes 1.11 to 1.15: lowercase fields and int scores
+	DialectLegacy Dialect = "legacy"
+
+	// DialectV1 is the k8s.io/kube-scheduler/extender/v1 shape of Kubernetes 1.16
+	// and later: the fields carry no json tag and the scores are int64
+	DialectV1 Dialect = "v1"
+)
+
+// MaxExtenderPriority is the highest score of an extender in both dialects,
+// kube-scheduler 1.16 and later scale it to the framework MaxNodeScore
+const MaxExtenderPriority = schedulerapi.MaxPriority
+
+// detect returns the dialect of the top level fields of an ExtenderArgs, the
+// legacy dialect when no field tells
+func detect(fields map[string]json.RawMessage) Dialect {
+	for _, name := range []string{"Pod", "Nodes", "NodeNames"} {
+		if _, ok := fields[name]; ok {
+			return DialectV1
+		}
+	}
+	return DialectLegacy
+}
+
+// ReadArgs decodes the ExtenderArgs of a filter or prioritize call in either
+// dialect, and returns the dialect of the caller
+func ReadArgs(r io.Reader) (*schedulerapiv1.ExtenderArgs, Dialect, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, DialectLegacy, err
+	}
+	return DecodeArgs(data)
+}
+
+// DecodeArgs decodes the ExtenderArgs in either dialect
+func DecodeArgs(data []byte) (*schedulerapiv1.ExtenderArgs, Dialect, error) {
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, DialectLegacy, fmt.Errorf("invalid extender args: %v", err)
+	}
+	dialect := detect(fields)
+
+	args := &schedulerapiv1.ExtenderArgs{}
+	if dialect == DialectV1 {
+		v1Args := &argsV1{}
+		if err := json.Unmarshal(data, v1Args); err != nil {
+			return nil, dialect, fmt.Errorf("invalid extender args: %v", err)
+		}
+		args.Pod = v1Args.Pod
+		args.Nodes = v1Args.Nodes
+		args.NodeNames = v1Args.NodeNames
+	} else if err := json.Unmarshal(data, args); err != nil {
+		return nil, dialect, fmt.Errorf("invalid extender args: %v", err)
+	}
+
+	if args.Pod == nil {
+		return nil, dialect, fmt.Errorf("invalid extender args: no pod")
+	}
+	// the extender is not nodeCacheCapable, kube-scheduler always sends the nodes
+	if args.Nodes == nil {
+		return nil, dialect, fmt.Errorf("invalid extender args: no nodes")
+	}
+	return args, dialect, nil
+}
+
+// ReadBindingArgs decodes the ExtenderBindingArgs of a bind call
+func ReadBindingArgs(r io.Reader) (*schedulerapiv1.ExtenderBindingArgs, Dialect, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, DialectLegacy, err
+	}
+	return DecodeBindingArgs(data)
+}
+
+// DecodeBindingArgs decodes the ExtenderBindingArgs. Their fields carry no json
+// tag in either dialect, so the dialect cannot be told and is the legacy one;
+// the reply has the same shape in both.
+func DecodeBindingArgs(data []byte) (*schedulerapiv1.ExtenderBindingArgs, Dialect, error) {
+	args := &schedulerapiv1.ExtenderBindingArgs{}
+	if err := json.Unmarshal(data, args); err != nil {
+		return nil, DialectLegacy, fmt.Errorf("invalid extender binding args: %v", err)
+	}
+	if args.PodName == "" || args.PodNamespace == "" || args.Node == "" {
+		return nil, DialectLegacy, fmt.Errorf("invalid extender binding args: no pod or node")
+	}
+	return args, DialectLegacy, nil
+}
+
+// FilterResult returns the filter result in the dialect
+func FilterResult(dialect Dialect, result *schedulerapiv1.ExtenderFilterResult) interface{} {
+	if dialect != DialectV1 {
+		return result
+	}
+	return &filterResultV1{
+		Nodes:       result.Nodes,
+		NodeNames:   result.NodeNames,
+		FailedNodes: result.FailedNodes,
+		Error:       result.Error,
+	}
+}
+
+// PriorityResult returns the scores in the dialect. The scheduler keeps them in
+// [0, MaxExtenderPriority], they are passed through unchanged.
+func PriorityResult(dialect Dialect, result schedulerapiv1.HostPriorityList) interface{} {
+	if dialect != DialectV1 {
+		return result
+	}
+
+	ret := make([]hostPriorityV1, 0, len(result))
+	for _, hp := range result {
+		ret = append(ret, hostPriorityV1{Host: hp.Host, Score: int64(hp.Score)})
+	}
+	return ret
+}
+
+// BindingResult returns the binding result in the dialect
+func BindingResult(dialect Dialect, result *schedulerapiv1.ExtenderBindingResult) interface{} {
+	if dialect != DialectV1 {
+		return result
+	}
+	return &bindingResultV1{Error: result.Error}
+}
+
+// argsV1 is the ExtenderArgs of k8s.io/kube-scheduler/extender/v1
+type argsV1 struct {
+	Pod       *corev1.Pod
+	Nodes     *corev1.NodeList
+	NodeNames *[]string
+}
+
+// filterResultV1 is the ExtenderFilterResult of k8s.io/kube-scheduler/extender/v1
+type filterResultV1 struct {
+	Nodes       *corev1.NodeList
+	NodeNames   *[]string
+	FailedNodes schedulerapiv1.FailedNodesMap
+	Error       string
+}
+
+// hostPriorityV1 is the HostPriority of k8s.io/kube-scheduler/extender/v1
+type hostPriorityV1 struct {
+	Host  string
+	Score int64
+}
+
+// bindingResultV1 is the ExtenderBindingResult of k8s.io/kube-scheduler/extender/v1
+type bindingResultV1 struct {
+	Error string
+}
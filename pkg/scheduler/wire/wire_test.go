@@ -0,0 +1,181 @@
+package wire
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	schedulerapiv1 "k8s.io/kubernetes/pkg/scheduler/api/v1"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+// versions are the kube-scheduler versions the extender supports, and the
+// dialect they speak
+var versions = []struct {
+	version string
+	dialect Dialect
+}{
+	{"1.11", DialectLegacy},
+	{"1.14", DialectLegacy},
+	{"1.16", DialectV1},
+	{"1.19", DialectV1},
+	{"1.22", DialectV1},
+	{"1.25", DialectV1},
+}
+
+func TestGolden(t *testing.T) {
+	for _, tc := range versions {
+		t.Run(tc.version, func(t *testing.T) {
+			dir := filepath.Join("testdata", tc.version)
+			data, err := ioutil.ReadFile(filepath.Join(dir, "args.json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			args, dialect, err := DecodeArgs(data)
+			if err != nil {
+				t.Fatalf("decode args: %v", err)
+			}
+			if dialect != tc.dialect {
+				t.Fatalf("dialect = %s, want %s", dialect, tc.dialect)
+			}
+			if args.Pod.Name != "web-0" || args.Pod.Labels["app"] != "web" {
+				t.Fatalf("unexpected pod %s/%s", args.Pod.Namespace, args.Pod.Name)
+			}
+			if args.Nodes == nil || len(args.Nodes.Items) != 2 {
+				t.Fatalf("unexpected nodes %v", args.Nodes)
+			}
+
+			filter := &schedulerapiv1.ExtenderFilterResult{
+				FailedNodes: schedulerapiv1.FailedNodesMap{
+					"node-b": "too many replicas of web on the node",
+				},
+			}
+			filter.Nodes = args.Nodes.DeepCopy()
+			filter.Nodes.Items = filter.Nodes.Items[:1]
+			compareGolden(t, filepath.Join(dir, "filter.golden.json"), FilterResult(dialect, filter))
+
+			priorities := schedulerapiv1.HostPriorityList{
+				{Host: "node-a", Score: 7},
+				{Host: "node-b", Score: MaxExtenderPriority},
+			}
+			compareGolden(t, filepath.Join(dir, "prioritize.golden.json"), PriorityResult(dialect, priorities))
+			checkFields(t, filepath.Join(dir, "filter.golden.json"), filterFields[dialect])
+			checkFields(t, filepath.Join(dir, "prioritize.golden.json"), priorityFields[dialect])
+
+			// the binding messages carry no json tag in any version, both
+			// dialects encode the reply the same
+			data, err = ioutil.ReadFile(filepath.Join(dir, "bind.json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			binding, _, err := DecodeBindingArgs(data)
+			if err != nil {
+				t.Fatalf("decode binding args: %v", err)
+			}
+			if binding.PodName != "web-0" || binding.PodUID != args.Pod.UID || binding.Node != "node-a" {
+				t.Fatalf("unexpected binding %+v", binding)
+			}
+			bind := &schedulerapiv1.ExtenderBindingResult{Error: "pod web-0 is already bound"}
+			compareGolden(t, filepath.Join(dir, "bind.golden.json"), BindingResult(dialect, bind))
+			checkFields(t, filepath.Join(dir, "bind.golden.json"), []string{"Error"})
+		})
+	}
+}
+
+func TestDecodeArgsErrors(t *testing.T) {
+	for _, data := range []string{
+		`not json`,
+		`{"nodes": {"items": []}}`,
+		`{"Pod": null, "NodeNames": ["node-a"]}`,
+		`{"pod": {"metadata": {"name": "web-0"}}}`,
+		`{"Pod": {"metadata": {"name": "web-0"}}, "Nodes": null, "NodeNames": ["node-a"]}`,
+	} {
+		if _, _, err := DecodeArgs([]byte(data)); err == nil {
+			t.Errorf("decode %s: expected an error", data)
+		}
+	}
+}
+
+// filterFields and priorityFields are the json fields kube-scheduler decodes
+// from the replies of each dialect, the goldens must not carry any other
+var filterFields = map[Dialect][]string{
+	DialectLegacy: {"nodes", "nodenames", "failedNodes", "error"},
+	DialectV1:     {"Nodes", "NodeNames", "FailedNodes", "Error"},
+}
+
+var priorityFields = map[Dialect][]string{
+	DialectLegacy: {"host", "score"},
+	DialectV1:     {"Host", "Score"},
+}
+
+// checkFields checks the golden reply, or every item of it when it is a list,
+// only has the fields of the dialect
+func checkFields(t *testing.T, path string, fields []string) {
+	t.Helper()
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var objects []map[string]json.RawMessage
+	if err := json.Unmarshal(data, &objects); err != nil {
+		object := map[string]json.RawMessage{}
+		if err := json.Unmarshal(data, &object); err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		objects = append(objects, object)
+	}
+
+	allowed := make(map[string]bool, len(fields))
+	for _, field := range fields {
+		allowed[field] = true
+	}
+	for _, object := range objects {
+		for field := range object {
+			if !allowed[field] {
+				t.Errorf("%s: unexpected field %q", path, field)
+			}
+		}
+	}
+}
+
+func TestDecodeBindingArgsErrors(t *testing.T) {
+	for _, data := range []string{
+		`not json`,
+		`{"PodName": "web-0", "PodNamespace": "default"}`,
+		`{"PodNamespace": "default", "Node": "node-a"}`,
+	} {
+		if _, _, err := DecodeBindingArgs([]byte(data)); err == nil {
+			t.Errorf("decode %s: expected an error", data)
+		}
+	}
+}
+
+func compareGolden(t *testing.T, path string, v interface{}) {
+	t.Helper()
+	got, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = append(got, '\n')
+
+	if *update {
+		if err := ioutil.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	want, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v, run the tests with -update to create it", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s differs\ngot:\n%s\nwant:\n%s", path, got, want)
+	}
+}
@@ -0,0 +1,107 @@
+package sli
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/xkcp0324/custom-scheduler/pkg/router/ginprom"
+	"k8s.io/klog"
+)
+
+var schedulingDur = &ginprom.Metric{
+	ID:          "schedulingDur",
+	Name:        "pod_scheduling_duration_seconds",
+	Description: "The time between the creation of a pod and its scheduling in seconds.",
+	Type:        "histogram_vec",
+	Args:        []string{"namespace", "profile"},
+	Buckets:     prometheus.ExponentialBuckets(0.01, 2, 18)}
+
+var startupDur = &ginprom.Metric{
+	ID:          "startupDur",
+	Name:        "pod_startup_duration_seconds",
+	Description: "The time between the scheduling of a pod and its running in seconds.",
+	Type:        "histogram_vec",
+	Args:        []string{"namespace", "profile"},
+	Buckets:     prometheus.ExponentialBuckets(0.5, 2, 12)}
+
+var pendingPods = &ginprom.Metric{
+	ID:          "pendingPods",
+	Name:        "pending_pods",
+	Description: "The number of pods pending longer than the threshold.",
+	Type:        "gauge_vec",
+	Args:        []string{"namespace", "profile"}}
+
+var oldestPendingAge = &ginprom.Metric{
+	ID:          "oldestPendingAge",
+	Name:        "oldest_pending_pod_age_seconds",
+	Description: "The age of the oldest pending pod in seconds.",
+	Type:        "gauge_vec",
+	Args:        []string{"namespace", "profile"}}
+
+var sliMetrics = []*ginprom.Metric{
+	schedulingDur,
+	startupDur,
+	pendingPods,
+	oldestPendingAge,
+}
+
+var registerOnce sync.Once
+
+func registerMetrics(subsystem string) {
+	registerOnce.Do(func() {
+		for _, metricDef := range sliMetrics {
+			metric := ginprom.NewMetric(metricDef, subsystem)
+			if err := prometheus.Register(metric); err != nil {
+				klog.Infof("%s could not be registered: %v", metricDef.Name, err)
+				continue
+			}
+			metricDef.MetricCollector = metric
+		}
+	})
+}
+
+func observeScheduling(namespace, profile string, d time.Duration) {
+	if h, ok := schedulingDur.MetricCollector.(*prometheus.HistogramVec); ok {
+		h.WithLabelValues(namespace, profile).Observe(d.Seconds())
+	}
+}
+
+func observeStartup(namespace, profile string, d time.Duration) {
+	if h, ok := startupDur.MetricCollector.(*prometheus.HistogramVec); ok {
+		h.WithLabelValues(namespace, profile).Observe(d.Seconds())
+	}
+}
+
+// pendingStats are the pending pods of a namespace and profile
+type pendingStats struct {
+	overThreshold int
+	oldest        time.Duration
+}
+
+// setPending sets the pending gauges and deletes the ones of the namespaces
+// which have no pending pod anymore, it returns the keys it set
+func setPending(stats map[group]*pendingStats, previous map[group]struct{}) map[group]struct{} {
+	count, ok := pendingPods.MetricCollector.(*prometheus.GaugeVec)
+	if !ok {
+		return nil
+	}
+	oldest, ok := oldestPendingAge.MetricCollector.(*prometheus.GaugeVec)
+	if !ok {
+		return nil
+	}
+
+	keys := make(map[group]struct{}, len(stats))
+	for key, s := range stats {
+		count.WithLabelValues(key.namespace, key.profile).Set(float64(s.overThreshold))
+		oldest.WithLabelValues(key.namespace, key.profile).Set(s.oldest.Seconds())
+		keys[key] = struct{}{}
+	}
+	for key := range previous {
+		if _, ok := keys[key]; !ok {
+			count.DeleteLabelValues(key.namespace, key.profile)
+			oldest.DeleteLabelValues(key.namespace, key.profile)
+		}
+	}
+	return keys
+}
@@ -0,0 +1,246 @@
+package sli
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/xkcp0324/custom-scheduler/pkg/scheduler"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	toolscache "k8s.io/client-go/tools/cache"
+	"k8s.io/klog"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+const (
+	// DefaultPendingThreshold is how long a pod pends before it is counted by the
+	// pending_pods gauge
+	DefaultPendingThreshold = time.Minute
+
+	// DefaultInterval is the interval between two updates of the pending gauges
+	DefaultInterval = 10 * time.Second
+)
+
+// Options are options for constructing a Controller
+type Options struct {
+	// SchedulerName is the schedulerName of the pods measured
+	SchedulerName string
+
+	// PendingThreshold is how long a pod pends before it is counted as pending
+	PendingThreshold time.Duration
+
+	// Interval is the interval between two updates of the pending gauges
+	Interval time.Duration
+
+	// MetricsSubsystem is the subsystem of the metrics
+	MetricsSubsystem string
+}
+
+// Controller measures the scheduling latency of the pods of a schedulerName:
+// the time from their creation to their scheduling, and from their scheduling
+// to their running, partitioned by namespace and profile. It also reports the
+// pods pending longer than a threshold. It only runs on the leader, so every
+// pod is observed once.
+type Controller struct {
+	mgr manager.Manager
+	opt Options
+
+	// started skips the transitions which happened before the controller ran,
+	// they were observed by the previous leader
+	started time.Time
+
+	lock sync.Mutex
+	// observed are the phases already recorded of the pods
+	observed map[types.UID]phase
+
+	// pendingKeys are the namespaces and profiles of the pending gauges
+	pendingKeys map[group]struct{}
+}
+
+// phase is the last transition of a pod recorded
+type phase int
+
+const (
+	phaseScheduled phase = iota + 1
+	phaseRunning
+)
+
+// group is the namespace and profile of a pod
+type group struct {
+	namespace string
+	profile   string
+}
+
+// NewController returns a Controller
+func NewController(mgr manager.Manager, opt *Options) (*Controller, error) {
+	o := *opt
+	if o.SchedulerName == "" {
+		return nil, fmt.Errorf("the sli controller requires a scheduler name")
+	}
+	if o.PendingThreshold <= 0 {
+		o.PendingThreshold = DefaultPendingThreshold
+	}
+	if o.Interval <= 0 {
+		o.Interval = DefaultInterval
+	}
+
+	registerMetrics(o.MetricsSubsystem)
+	return &Controller{
+		mgr:      mgr,
+		opt:      o,
+		observed: make(map[types.UID]phase),
+	}, nil
+}
+
+// Start records the pod transitions and updates the pending gauges until the
+// stop channel is closed
+func (c *Controller) Start(stopCh <-chan struct{}) error {
+	c.started = time.Now()
+
+	informer, err := c.mgr.GetCache().GetInformer(&corev1.Pod{})
+	if err != nil {
+		return fmt.Errorf("get pod informer: %v", err)
+	}
+	informer.AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+		AddFunc:    c.onPod,
+		UpdateFunc: func(_, newObj interface{}) { c.onPod(newObj) },
+		DeleteFunc: c.onPodDeleted,
+	})
+
+	if !c.mgr.GetCache().WaitForCacheSync(stopCh) {
+		return fmt.Errorf("cache of the sli controller did not sync")
+	}
+
+	klog.Infof("start sli controller scheduler name: %s pending threshold: %v", c.opt.SchedulerName, c.opt.PendingThreshold)
+	wait.Until(c.updatePending, c.opt.Interval, stopCh)
+	return nil
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable, only the leader
+// observes the pods
+func (c *Controller) NeedLeaderElection() bool {
+	return true
+}
+
+func (c *Controller) onPod(obj interface{}) {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok || pod.Spec.SchedulerName != c.opt.SchedulerName || pod.Spec.NodeName == "" {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	last := c.observed[pod.UID]
+	g := groupOf(pod)
+	if last < phaseScheduled {
+		scheduledAt := scheduledTime(pod)
+		if scheduledAt.After(c.started) {
+			observeScheduling(g.namespace, g.profile, scheduledAt.Sub(pod.CreationTimestamp.Time))
+		}
+		last = phaseScheduled
+	}
+	if last < phaseRunning && pod.Status.Phase == corev1.PodRunning {
+		runningAt := runningTime(pod)
+		if runningAt.After(c.started) {
+			observeStartup(g.namespace, g.profile, runningAt.Sub(scheduledTime(pod)))
+		}
+		last = phaseRunning
+	}
+	if isTerminated(pod) {
+		// a pod which terminated before it was seen running has no startup
+		last = phaseRunning
+	}
+	c.observed[pod.UID] = last
+}
+
+func (c *Controller) onPodDeleted(obj interface{}) {
+	if tombstone, ok := obj.(toolscache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.observed, pod.UID)
+}
+
+// updatePending counts the pods pending longer than the threshold and the age
+// of the oldest pending pod of every namespace and profile
+func (c *Controller) updatePending() {
+	podList := &corev1.PodList{}
+	if err := c.mgr.GetClient().List(context.Background(), podList); err != nil {
+		klog.Errorf("list pod err: %+v", err)
+		return
+	}
+
+	now := time.Now()
+	stats := make(map[group]*pendingStats)
+	for i := range podList.Items {
+		pod := &podList.Items[i]
+		if pod.Spec.SchedulerName != c.opt.SchedulerName || pod.Spec.NodeName != "" ||
+			pod.DeletionTimestamp != nil || isTerminated(pod) {
+			continue
+		}
+
+		g := groupOf(pod)
+		s, ok := stats[g]
+		if !ok {
+			s = &pendingStats{}
+			stats[g] = s
+		}
+		age := now.Sub(pod.CreationTimestamp.Time)
+		if age > s.oldest {
+			s.oldest = age
+		}
+		if age > c.opt.PendingThreshold {
+			s.overThreshold++
+		}
+	}
+
+	c.pendingKeys = setPending(stats, c.pendingKeys)
+}
+
+func groupOf(pod *corev1.Pod) group {
+	return group{namespace: pod.Namespace, profile: scheduler.ProfileOf(pod)}
+}
+
+// scheduledTime returns when the PodScheduled condition turned true, or now
+// when the pod has no such condition
+func scheduledTime(pod *corev1.Pod) time.Time {
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == corev1.PodScheduled && cond.Status == corev1.ConditionTrue && !cond.LastTransitionTime.IsZero() {
+			return cond.LastTransitionTime.Time
+		}
+	}
+	return time.Now()
+}
+
+// runningTime returns when the first container of the pod started, or now when
+// no container reports it
+func runningTime(pod *corev1.Pod) time.Time {
+	var ret time.Time
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.State.Running == nil {
+			continue
+		}
+		startedAt := status.State.Running.StartedAt.Time
+		if ret.IsZero() || startedAt.Before(ret) {
+			ret = startedAt
+		}
+	}
+	if ret.IsZero() {
+		return time.Now()
+	}
+	return ret
+}
+
+func isTerminated(pod *corev1.Pod) bool {
+	return pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed
+}
@@ -0,0 +1,166 @@
+package standalone
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	v1helper "k8s.io/kubernetes/pkg/apis/core/v1/helper"
+)
+
+// The reasons a node does not fit a pod, worded like kube-scheduler
+const (
+	reasonUnschedulable = "node(s) were unschedulable"
+	reasonNotReady      = "node(s) were not ready"
+	reasonNodeSelector  = "node(s) didn't match node selector"
+	reasonTaints        = "node(s) had taints that the pod didn't tolerate"
+	reasonTooManyPods   = "Too many pods"
+)
+
+// fitNodes returns the nodes which pass the basic checks of kube-scheduler:
+// node conditions, node selector and affinity, taints and resources. The pods
+// are the ones running on each node, assumed pods included.
+func fitNodes(pod *corev1.Pod, nodes []corev1.Node, podsByNode map[string][]*corev1.Pod) ([]corev1.Node, map[string]string) {
+	requests := podRequests(pod)
+
+	var ret []corev1.Node
+	failed := make(map[string]string)
+	for i := range nodes {
+		node := &nodes[i]
+		if reason := fitNode(pod, requests, node, podsByNode[node.Name]); reason != "" {
+			failed[node.Name] = reason
+			continue
+		}
+		ret = append(ret, *node)
+	}
+	return ret, failed
+}
+
+// fitNode returns why the pod does not fit the node, empty when it fits
+func fitNode(pod *corev1.Pod, requests corev1.ResourceList, node *corev1.Node, pods []*corev1.Pod) string {
+	if node.Spec.Unschedulable {
+		return reasonUnschedulable
+	}
+	if !isNodeReady(node) {
+		return reasonNotReady
+	}
+	if !matchNodeSelector(pod, node) {
+		return reasonNodeSelector
+	}
+	if !toleratesTaints(pod, node) {
+		return reasonTaints
+	}
+
+	allocatable := node.Status.Allocatable
+	if podsCap, ok := allocatable[corev1.ResourcePods]; ok && int64(len(pods)+1) > podsCap.Value() {
+		return reasonTooManyPods
+	}
+
+	used := corev1.ResourceList{}
+	for _, p := range pods {
+		addResources(used, podRequests(p))
+	}
+	names := make([]string, 0, len(requests))
+	for name := range requests {
+		names = append(names, string(name))
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		request := requests[corev1.ResourceName(name)]
+		if request.IsZero() {
+			continue
+		}
+		capacity, ok := allocatable[corev1.ResourceName(name)]
+		if !ok {
+			return "Insufficient " + name
+		}
+		total := used[corev1.ResourceName(name)]
+		total.Add(request)
+		if total.Cmp(capacity) > 0 {
+			return "Insufficient " + name
+		}
+	}
+	return ""
+}
+
+// matchNodeSelector checks the node selector and the required node affinity
+func matchNodeSelector(pod *corev1.Pod, node *corev1.Node) bool {
+	if !labels.SelectorFromSet(pod.Spec.NodeSelector).Matches(labels.Set(node.Labels)) {
+		return false
+	}
+
+	affinity := pod.Spec.Affinity
+	if affinity == nil || affinity.NodeAffinity == nil ||
+		affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+		return true
+	}
+	terms := affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms
+	return v1helper.MatchNodeSelectorTerms(terms, labels.Set(node.Labels), fields.Set{"metadata.name": node.Name})
+}
+
+// toleratesTaints checks the NoSchedule and NoExecute taints of the node
+func toleratesTaints(pod *corev1.Pod, node *corev1.Node) bool {
+	return v1helper.TolerationsTolerateTaintsWithFilter(pod.Spec.Tolerations, node.Spec.Taints, func(t *corev1.Taint) bool {
+		return t.Effect == corev1.TaintEffectNoSchedule || t.Effect == corev1.TaintEffectNoExecute
+	})
+}
+
+func isNodeReady(node *corev1.Node) bool {
+	for _, cond := range node.Status.Conditions {
+		if cond.Type == corev1.NodeReady {
+			return cond.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// podRequests returns the sum of the requests of the containers, or the
+// requests of the largest init container when they are larger
+func podRequests(pod *corev1.Pod) corev1.ResourceList {
+	ret := corev1.ResourceList{}
+	for i := range pod.Spec.Containers {
+		addResources(ret, pod.Spec.Containers[i].Resources.Requests)
+	}
+	for i := range pod.Spec.InitContainers {
+		for name, quantity := range pod.Spec.InitContainers[i].Resources.Requests {
+			if current, ok := ret[name]; !ok || quantity.Cmp(current) > 0 {
+				ret[name] = quantity.DeepCopy()
+			}
+		}
+	}
+	return ret
+}
+
+func addResources(dst, src corev1.ResourceList) {
+	for name, quantity := range src {
+		current, ok := dst[name]
+		if !ok {
+			current = resource.Quantity{}
+		}
+		current.Add(quantity)
+		dst[name] = current
+	}
+}
+
+// summarize counts the nodes by reason like kube-scheduler, e.g.
+// "0/3 nodes are available: 1 Insufficient cpu, 2 node(s) were not ready."
+func summarize(total int, failed map[string]string) string {
+	counts := make(map[string]int)
+	for _, reason := range failed {
+		counts[reason]++
+	}
+
+	reasons := make([]string, 0, len(counts))
+	for reason, count := range counts {
+		reasons = append(reasons, fmt.Sprintf("%d %s", count, reason))
+	}
+	sort.Strings(reasons)
+	if len(reasons) == 0 {
+		return fmt.Sprintf("0/%d nodes are available.", total)
+	}
+	return fmt.Sprintf("0/%d nodes are available: %s.", total, strings.Join(reasons, ", "))
+}
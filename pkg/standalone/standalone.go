@@ -0,0 +1,411 @@
+package standalone
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/xkcp0324/custom-scheduler/pkg/scheduler"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	toolscache "k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog"
+	schedulerapiv1 "k8s.io/kubernetes/pkg/scheduler/api/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+const (
+	// EventReasonScheduled is emitted when a pod is bound
+	EventReasonScheduled = "Scheduled"
+
+	// EventReasonFailedScheduling is emitted when no node fits a pod, or its
+	// binding failed
+	EventReasonFailedScheduling = "FailedScheduling"
+
+	// DefaultSchedulerName is the schedulerName of the pods scheduled in process
+	DefaultSchedulerName = "custom-scheduler"
+
+	// DefaultInitialBackoff and DefaultMaxBackoff bound the delay before an
+	// unschedulable pod is tried again, like kube-scheduler
+	DefaultInitialBackoff = time.Second
+	DefaultMaxBackoff     = 10 * time.Second
+
+	// assumeTTL is how long a bound pod is counted on its node when the cache
+	// does not see the binding
+	assumeTTL = 30 * time.Second
+)
+
+// Options are options for constructing a Standalone
+type Options struct {
+	// SchedulerName is the schedulerName of the pods to schedule
+	SchedulerName string
+
+	// InitialBackoff is the delay before the second try of an unschedulable pod,
+	// it doubles on every failure up to MaxBackoff
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// Standalone schedules the pending pods of its schedulerName without
+// kube-scheduler: it runs the basic node checks then the filters and scores of
+// the scheduler, and binds the pod to the best node. An unschedulable pod is
+// tried again after a backoff, or as soon as a node or a pod changes. It only
+// runs on the leader.
+type Standalone struct {
+	mgr       manager.Manager
+	scheduler scheduler.Scheduler
+	recorder  record.EventRecorder
+	opt       Options
+	queue     workqueue.RateLimitingInterface
+
+	lock sync.Mutex
+	// unschedulable are the keys of the pods which did not fit any node
+	unschedulable map[string]struct{}
+	// assumed are the pods bound by this scheduler the cache may not see bound yet
+	assumed map[types.UID]assumedPod
+}
+
+type assumedPod struct {
+	pod     *corev1.Pod
+	expires time.Time
+}
+
+// NewStandalone returns a Standalone
+func NewStandalone(mgr manager.Manager, s scheduler.Scheduler, opt *Options) *Standalone {
+	o := *opt
+	if o.SchedulerName == "" {
+		o.SchedulerName = DefaultSchedulerName
+	}
+	if o.InitialBackoff <= 0 {
+		o.InitialBackoff = DefaultInitialBackoff
+	}
+	if o.MaxBackoff < o.InitialBackoff {
+		o.MaxBackoff = DefaultMaxBackoff
+		if o.MaxBackoff < o.InitialBackoff {
+			o.MaxBackoff = o.InitialBackoff
+		}
+	}
+
+	return &Standalone{
+		mgr:       mgr,
+		scheduler: s,
+		recorder:  mgr.GetEventRecorderFor(o.SchedulerName),
+		opt:       o,
+		queue: workqueue.NewNamedRateLimitingQueue(
+			workqueue.NewItemExponentialFailureRateLimiter(o.InitialBackoff, o.MaxBackoff), "standalone"),
+		unschedulable: make(map[string]struct{}),
+		assumed:       make(map[types.UID]assumedPod),
+	}
+}
+
+// Start schedules the pods until the stop channel is closed
+func (s *Standalone) Start(stopCh <-chan struct{}) error {
+	defer s.queue.ShutDown()
+
+	podInformer, err := s.mgr.GetCache().GetInformer(&corev1.Pod{})
+	if err != nil {
+		return fmt.Errorf("get pod informer: %v", err)
+	}
+	nodeInformer, err := s.mgr.GetCache().GetInformer(&corev1.Node{})
+	if err != nil {
+		return fmt.Errorf("get node informer: %v", err)
+	}
+	podInformer.AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { s.onPod(nil, obj) },
+		UpdateFunc: s.onPod,
+		DeleteFunc: s.onPodDeleted,
+	})
+	nodeInformer.AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+		AddFunc:    func(interface{}) { s.retryUnschedulable() },
+		UpdateFunc: s.onNodeUpdated,
+	})
+
+	if !s.mgr.GetCache().WaitForCacheSync(stopCh) {
+		return fmt.Errorf("cache of the standalone scheduler did not sync")
+	}
+
+	klog.Infof("start standalone scheduler name: %s backoff: %v-%v", s.opt.SchedulerName, s.opt.InitialBackoff, s.opt.MaxBackoff)
+	// a single worker, the resource checks count the pods it bound before
+	go wait.Until(s.worker, time.Second, stopCh)
+	<-stopCh
+	return nil
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable, only the leader
+// binds pods
+func (s *Standalone) NeedLeaderElection() bool {
+	return true
+}
+
+// responsible returns whether the pod waits for this scheduler
+func (s *Standalone) responsible(pod *corev1.Pod) bool {
+	return pod.Spec.SchedulerName == s.opt.SchedulerName && pod.Spec.NodeName == "" &&
+		pod.DeletionTimestamp == nil &&
+		pod.Status.Phase != corev1.PodSucceeded && pod.Status.Phase != corev1.PodFailed
+}
+
+func (s *Standalone) onPod(oldObj, newObj interface{}) {
+	pod, ok := newObj.(*corev1.Pod)
+	if !ok {
+		return
+	}
+	if s.responsible(pod) {
+		if key, err := toolscache.MetaNamespaceKeyFunc(pod); err == nil {
+			s.queue.Add(key)
+		}
+		return
+	}
+	if pod.Spec.NodeName == "" {
+		return
+	}
+
+	s.lock.Lock()
+	delete(s.assumed, pod.UID)
+	s.lock.Unlock()
+
+	// a pod leaving a node frees its resources
+	if old, ok := oldObj.(*corev1.Pod); ok && !isTerminated(old) && isTerminated(pod) {
+		s.retryUnschedulable()
+	}
+}
+
+func (s *Standalone) onPodDeleted(obj interface{}) {
+	if tombstone, ok := obj.(toolscache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		return
+	}
+
+	s.lock.Lock()
+	delete(s.assumed, pod.UID)
+	s.lock.Unlock()
+
+	if pod.Spec.NodeName != "" {
+		s.retryUnschedulable()
+	}
+}
+
+// onNodeUpdated retries the unschedulable pods when a node changes in a way
+// which may let them fit, the status heartbeats are ignored
+func (s *Standalone) onNodeUpdated(oldObj, newObj interface{}) {
+	old, ok := oldObj.(*corev1.Node)
+	if !ok {
+		return
+	}
+	node, ok := newObj.(*corev1.Node)
+	if !ok {
+		return
+	}
+	if reflect.DeepEqual(old.Spec, node.Spec) && reflect.DeepEqual(old.Labels, node.Labels) &&
+		reflect.DeepEqual(old.Status.Allocatable, node.Status.Allocatable) &&
+		isNodeReady(old) == isNodeReady(node) {
+		return
+	}
+	s.retryUnschedulable()
+}
+
+// retryUnschedulable queues the unschedulable pods without waiting for their backoff
+func (s *Standalone) retryUnschedulable() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for key := range s.unschedulable {
+		s.queue.Add(key)
+	}
+	s.unschedulable = make(map[string]struct{})
+}
+
+func (s *Standalone) worker() {
+	for s.processNextItem() {
+	}
+}
+
+func (s *Standalone) processNextItem() bool {
+	item, quit := s.queue.Get()
+	if quit {
+		return false
+	}
+	defer s.queue.Done(item)
+
+	key := item.(string)
+	if s.schedule(key) {
+		s.lock.Lock()
+		s.unschedulable[key] = struct{}{}
+		s.lock.Unlock()
+		s.queue.AddRateLimited(key)
+		return true
+	}
+
+	s.lock.Lock()
+	delete(s.unschedulable, key)
+	s.lock.Unlock()
+	s.queue.Forget(key)
+	return true
+}
+
+// schedule tries to bind the pod to a node, it returns whether the pod must be
+// tried again
+func (s *Standalone) schedule(key string) bool {
+	namespace, name, err := toolscache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		klog.Errorf("invalid pod key %q: %v", key, err)
+		return false
+	}
+
+	ctx := context.Background()
+	cl := s.mgr.GetClient()
+	pod := &corev1.Pod{}
+	if err := cl.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, pod); err != nil {
+		if apierrors.IsNotFound(err) {
+			return false
+		}
+		klog.Errorf("get pod %s err: %+v", key, err)
+		return true
+	}
+	if !s.responsible(pod) || s.isAssumed(pod) {
+		return false
+	}
+
+	nodeList := &corev1.NodeList{}
+	if err := cl.List(ctx, nodeList); err != nil {
+		klog.Errorf("list node err: %+v", err)
+		return true
+	}
+	podList := &corev1.PodList{}
+	if err := cl.List(ctx, podList); err != nil {
+		klog.Errorf("list pod err: %+v", err)
+		return true
+	}
+
+	nodes, failed := fitNodes(pod, nodeList.Items, s.podsByNode(podList.Items))
+	if len(nodes) > 0 {
+		result, err := s.scheduler.Filter(ctx, &schedulerapiv1.ExtenderArgs{
+			Pod:   pod,
+			Nodes: &corev1.NodeList{Items: nodes},
+		})
+		if err != nil {
+			s.recorder.Eventf(pod, corev1.EventTypeWarning, EventReasonFailedScheduling, "filter failed: %v", err)
+			return true
+		}
+		for nodeName, reason := range result.FailedNodes {
+			failed[nodeName] = reason
+		}
+		nodes = nil
+		if result.Nodes != nil {
+			nodes = result.Nodes.Items
+		}
+	}
+	if len(nodes) == 0 {
+		message := summarize(len(nodeList.Items), failed)
+		klog.V(3).Infof("pod %s is unschedulable: %s", key, message)
+		s.recorder.Event(pod, corev1.EventTypeWarning, EventReasonFailedScheduling, message)
+		return true
+	}
+
+	host := s.selectHost(ctx, pod, nodes)
+	err = s.scheduler.Bind(ctx, &schedulerapiv1.ExtenderBindingArgs{
+		PodName:      pod.Name,
+		PodNamespace: pod.Namespace,
+		PodUID:       pod.UID,
+		Node:         host,
+	})
+	if err != nil {
+		klog.Errorf("bind pod %s to node %s err: %+v", key, host, err)
+		s.recorder.Eventf(pod, corev1.EventTypeWarning, EventReasonFailedScheduling, "Binding rejected: %v", err)
+		return true
+	}
+
+	s.assume(pod, host)
+	klog.V(3).Infof("bound pod %s to node %s", key, host)
+	s.recorder.Eventf(pod, corev1.EventTypeNormal, EventReasonScheduled, "Successfully assigned %s/%s to %s", pod.Namespace, pod.Name, host)
+	return false
+}
+
+// selectHost returns the node with the highest score, a random one among the
+// ties. The nodes keep a zero score when the priorities fail.
+func (s *Standalone) selectHost(ctx context.Context, pod *corev1.Pod, nodes []corev1.Node) string {
+	priorities, err := s.scheduler.Priority(ctx, &schedulerapiv1.ExtenderArgs{
+		Pod:   pod,
+		Nodes: &corev1.NodeList{Items: nodes},
+	})
+	if err != nil || len(priorities) == 0 {
+		if err != nil {
+			klog.Errorf("prioritize nodes of pod %s/%s err: %+v", pod.Namespace, pod.Name, err)
+		}
+		return nodes[rand.Intn(len(nodes))].Name
+	}
+
+	var best []string
+	maxScore := priorities[0].Score
+	for _, hp := range priorities {
+		switch {
+		case hp.Score > maxScore:
+			maxScore = hp.Score
+			best = []string{hp.Host}
+		case hp.Score == maxScore:
+			best = append(best, hp.Host)
+		}
+	}
+	return best[rand.Intn(len(best))]
+}
+
+// podsByNode returns the pods counted on every node, the pods assumed on a node
+// are added until the cache sees them bound
+func (s *Standalone) podsByNode(pods []corev1.Pod) map[string][]*corev1.Pod {
+	ret := make(map[string][]*corev1.Pod)
+	seen := make(map[types.UID]struct{}, len(pods))
+	for i := range pods {
+		pod := &pods[i]
+		if pod.Spec.NodeName == "" || isTerminated(pod) {
+			continue
+		}
+		seen[pod.UID] = struct{}{}
+		ret[pod.Spec.NodeName] = append(ret[pod.Spec.NodeName], pod)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := time.Now()
+	for uid, a := range s.assumed {
+		if now.After(a.expires) {
+			delete(s.assumed, uid)
+			continue
+		}
+		if _, ok := seen[uid]; !ok {
+			ret[a.pod.Spec.NodeName] = append(ret[a.pod.Spec.NodeName], a.pod)
+		}
+	}
+	return ret
+}
+
+// assume counts the pod on the node until the cache sees it bound
+func (s *Standalone) assume(pod *corev1.Pod, nodeName string) {
+	assumed := pod.DeepCopy()
+	assumed.Spec.NodeName = nodeName
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.assumed[pod.UID] = assumedPod{pod: assumed, expires: time.Now().Add(assumeTTL)}
+}
+
+// isAssumed returns whether the pod was bound but the cache did not see it yet
+func (s *Standalone) isAssumed(pod *corev1.Pod) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	a, ok := s.assumed[pod.UID]
+	return ok && time.Now().Before(a.expires)
+}
+
+func isTerminated(pod *corev1.Pod) bool {
+	return pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed
+}